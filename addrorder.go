@@ -0,0 +1,66 @@
+package dnscache
+
+import "strings"
+
+// AddrOrder is the order of the IPv4 and IPv6 addresses of a host, as
+// configured by Resolver.AddrOrder. Within a family, the order of the
+// upstream resolver is kept.
+type AddrOrder int
+
+const (
+	// OrderUpstream keeps the order of the upstream resolver. The system
+	// resolver, used by default, orders the addresses following RFC 6724,
+	// e.g. IPv6 first on hosts with IPv6 connectivity.
+	OrderUpstream AddrOrder = iota
+
+	// PreferIPv4 serves the IPv4 addresses before the IPv6 ones.
+	PreferIPv4
+
+	// PreferIPv6 serves the IPv6 addresses before the IPv4 ones.
+	PreferIPv6
+
+	// InterleaveIPv6First alternates between the families starting with
+	// IPv6, as recommended for Happy Eyeballs (RFC 8305).
+	InterleaveIPv6First
+
+	// InterleaveIPv4First alternates between the families starting with
+	// IPv4.
+	InterleaveIPv4First
+)
+
+// orderAddrs returns addrs ordered according to order.
+func orderAddrs(addrs []string, order AddrOrder) []string {
+	var v4, v6 []string
+	for _, addr := range addrs {
+		if strings.Contains(addr, ":") {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	if len(v4) == 0 || len(v6) == 0 {
+		return addrs
+	}
+
+	first, second := v4, v6
+	if order == PreferIPv6 || order == InterleaveIPv6First {
+		first, second = v6, v4
+	}
+	ordered := make([]string, 0, len(addrs))
+	switch order {
+	case PreferIPv4, PreferIPv6:
+		ordered = append(append(ordered, first...), second...)
+	case InterleaveIPv4First, InterleaveIPv6First:
+		for i := 0; i < len(first) || i < len(second); i++ {
+			if i < len(first) {
+				ordered = append(ordered, first[i])
+			}
+			if i < len(second) {
+				ordered = append(ordered, second[i])
+			}
+		}
+	default:
+		return addrs
+	}
+	return ordered
+}
@@ -0,0 +1,25 @@
+package dnscache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderAddrs(t *testing.T) {
+	addrs := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3", "2001:db8::2"}
+	tests := []struct {
+		order AddrOrder
+		want  []string
+	}{
+		{OrderUpstream, addrs},
+		{PreferIPv4, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1", "2001:db8::2"}},
+		{PreferIPv6, []string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2", "192.0.2.3"}},
+		{InterleaveIPv6First, []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}},
+		{InterleaveIPv4First, []string{"192.0.2.1", "2001:db8::1", "192.0.2.2", "2001:db8::2", "192.0.2.3"}},
+	}
+	for _, tt := range tests {
+		if got := orderAddrs(addrs, tt.order); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("orderAddrs(%d) = %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
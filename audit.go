@@ -0,0 +1,102 @@
+package dnscache
+
+import (
+	"sync"
+	"time"
+)
+
+// Event describes a lookup answered by a Resolver, as recorded with
+// Resolver.AuditSize.
+type Event struct {
+	// Time is when the lookup started and Duration how long it took.
+	Time     time.Time
+	Duration time.Duration
+
+	// Name is the host looked up, or the address of a reverse lookup when
+	// Reverse is true. Network is "ip4" or "ip6" for the lookups of
+	// LookupNetHost limited to one address family. Type is the type of the
+	// records looked up.
+	Name    string
+	Reverse bool
+	Network string
+	Type    RecordType
+
+	// Records are the addresses, or names, returned and Err the error.
+	Records []string
+	Err     error
+
+	// Cached reports whether the lookup was answered from the cache, and
+	// Source names the resolver which provided the records and Upstream
+	// the upstream it got them from, as reported by EntryInfo.
+	Cached   bool
+	Source   string
+	Upstream string
+}
+
+// auditLog is the ring buffer of the last events.
+type auditLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func (l *auditLog) add(e Event) {
+	l.mu.Lock()
+	l.events[l.next] = e
+	if l.next++; l.next == len(l.events) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// RecentEvents returns the last n lookups recorded with AuditSize, oldest
+// first. It returns fewer events if fewer were recorded, and all of them
+// if n is negative.
+func (r *Resolver) RecentEvents(n int) []Event {
+	r.once.Do(r.init)
+	l := r.audit
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recorded := l.next
+	if l.full {
+		recorded = len(l.events)
+	}
+	if n < 0 || n > recorded {
+		n = recorded
+	}
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = l.events[(l.next-n+i+len(l.events))%len(l.events)]
+	}
+	return events
+}
+
+// recordLookup records in the audit log the lookup of key started at start
+// and answered with rrs and err, as reported in res.
+func (r *Resolver) recordLookup(key cacheKey, start time.Time, rrs []string, err error, res *Result) {
+	e := Event{
+		Time:     start,
+		Duration: r.now().Sub(start),
+		Name:     key.name,
+		Reverse:  key.rtype == TypePTR,
+		Network:  keyNetworks[key.rtype],
+		Type:     key.rtype,
+		// The records are shared with the cache and the caller.
+		Records: append([]string(nil), rrs...),
+		Err:     err,
+		Cached:  res.Cached,
+	}
+	s := r.shard(key)
+	s.mu.RLock()
+	if entry, found := s.entries[key]; found {
+		e.Source = entry.source
+		e.Upstream = entry.upstream
+	}
+	s.mu.RUnlock()
+	r.audit.add(e)
+}
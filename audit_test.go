@@ -0,0 +1,54 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_RecentEvents(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("a.example.com", "192.0.2.1")
+	upstream.SetHostError("b.example.com", dnscachetest.NotFound("b.example.com"))
+	upstream.SetAddr("192.0.2.1", "a.example.com.")
+	r := &Resolver{Resolver: upstream, AuditSize: 3}
+	ctx := context.Background()
+	if events := r.RecentEvents(-1); len(events) != 0 {
+		t.Fatalf("RecentEvents() = %+v before any lookup", events)
+	}
+
+	_, _ = r.LookupHost(ctx, "a.example.com")
+	_, _ = r.LookupHost(ctx, "a.example.com")
+	_, _ = r.LookupHost(ctx, "b.example.com")
+	_, _ = r.LookupAddr(ctx, "192.0.2.1")
+
+	events := r.RecentEvents(-1)
+	if len(events) != 3 {
+		t.Fatalf("RecentEvents(-1) returned %d events, want 3", len(events))
+	}
+	if e := events[0]; e.Name != "a.example.com" || !e.Cached || e.Source != "*dnscachetest.Resolver" {
+		t.Errorf("events[0] = %+v, want the cached lookup of a.example.com", e)
+	}
+	if e := events[1]; e.Name != "b.example.com" || e.Cached || e.Err == nil {
+		t.Errorf("events[1] = %+v, want the failed lookup of b.example.com", e)
+	}
+	if e := events[2]; e.Name != "192.0.2.1" || !e.Reverse || len(e.Records) != 1 {
+		t.Errorf("events[2] = %+v, want the reverse lookup", e)
+	}
+	if last := r.RecentEvents(1); len(last) != 1 || last[0].Name != "192.0.2.1" {
+		t.Errorf("RecentEvents(1) = %+v, want the last lookup", last)
+	}
+}
+
+func TestResolver_RecentEventsCopied(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, AuditSize: 1}
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs[0] = "192.0.2.99"
+	if events := r.RecentEvents(1); len(events) != 1 || events[0].Records[0] != "216.58.192.238" {
+		t.Errorf("RecentEvents(1) = %+v, want the records as answered", events)
+	}
+}
@@ -0,0 +1,112 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for lookups of a name whose upstream lookups
+// kept failing, while its circuit breaker is open, see
+// Resolver.BreakerThreshold.
+var ErrCircuitOpen = errors.New("dnscache: circuit breaker open")
+
+// defaultBreakerOpenDuration is used when Resolver.BreakerOpenDuration is
+// zero.
+const defaultBreakerOpenDuration = 30 * time.Second
+
+// breaker is the circuit breaker of a name with failed upstream lookups.
+type breaker struct {
+	failures    int
+	lastFailure time.Time
+
+	// openUntil is when the breaker goes half-open, letting a single probe
+	// lookup through, probing while it runs.
+	openUntil time.Time
+	probing   bool
+}
+
+type breakers struct {
+	mu sync.Mutex
+	m  map[cacheKey]*breaker
+}
+
+func (r *Resolver) breakerOpenDuration() time.Duration {
+	if r.BreakerOpenDuration > 0 {
+		return r.BreakerOpenDuration
+	}
+	return defaultBreakerOpenDuration
+}
+
+// breakerAllow reports whether an upstream lookup of key may start.
+func (r *Resolver) breakerAllow(key cacheKey) bool {
+	if r.BreakerThreshold <= 0 {
+		return true
+	}
+	r.breakers.mu.Lock()
+	defer r.breakers.mu.Unlock()
+	b := r.breakers.m[key]
+	switch {
+	case b == nil || b.failures < r.BreakerThreshold:
+		return true
+	case b.probing || r.now().Before(b.openUntil):
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// breakerRecord records the outcome of an upstream lookup of key. Errors of
+// the resolver itself, such as rate limiting, are not failures of the name,
+// but still end a probe so that the next lookup can probe again.
+func (r *Resolver) breakerRecord(key cacheKey, err error) {
+	if r.BreakerThreshold <= 0 {
+		return
+	}
+	r.breakers.mu.Lock()
+	defer r.breakers.mu.Unlock()
+	switch {
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrTooManyLookups), errors.Is(err, context.Canceled),
+		errors.Is(err, ErrUnsupportedRecordType):
+		if b := r.breakers.m[key]; b != nil {
+			b.probing = false
+		}
+		return
+	}
+	if err == nil {
+		delete(r.breakers.m, key)
+		return
+	}
+	b := r.breakers.m[key]
+	if b == nil {
+		if r.breakers.m == nil {
+			r.breakers.m = make(map[cacheKey]*breaker)
+		}
+		b = &breaker{}
+		r.breakers.m[key] = b
+	}
+	now := r.now()
+	b.failures++
+	b.lastFailure = now
+	b.probing = false
+	if b.failures >= r.BreakerThreshold {
+		b.openUntil = now.Add(r.breakerOpenDuration())
+	}
+}
+
+// pruneBreakers forgets the breakers of names not looked up for longer than
+// the open duration, so that names failing once do not accumulate.
+func (r *Resolver) pruneBreakers() {
+	if r.BreakerThreshold <= 0 {
+		return
+	}
+	r.breakers.mu.Lock()
+	defer r.breakers.mu.Unlock()
+	now := r.now()
+	for key, b := range r.breakers.m {
+		if !b.probing && now.Sub(b.lastFailure) > r.breakerOpenDuration() && now.After(b.openUntil) {
+			delete(r.breakers.m, key)
+		}
+	}
+}
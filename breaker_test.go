@@ -0,0 +1,114 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_CircuitBreaker(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHostError("example.com", dnscachetest.Temporary("example.com"))
+	clock := newFakeClock()
+	r := &Resolver{Resolver: upstream, Clock: clock, BreakerThreshold: 2, BreakerOpenDuration: 30 * time.Second}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.LookupHost(ctx, "example.com"); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("lookup %d: err = %v, want the upstream error", i, err)
+		}
+	}
+	if _, err := r.LookupHost(ctx, "example.com"); err != ErrCircuitOpen {
+		t.Fatalf("err = %v, want %v", err, ErrCircuitOpen)
+	}
+	if n := upstream.Calls("example.com"); n != 2 {
+		t.Errorf("upstream called %d times, want 2", n)
+	}
+	if n := r.Stats().BreakerRejections; n != 1 {
+		t.Errorf("BreakerRejections = %d, want 1", n)
+	}
+
+	// The probe fails and opens the breaker again.
+	clock.Advance(30 * time.Second)
+	if _, err := r.LookupHost(ctx, "example.com"); err == nil || err == ErrCircuitOpen {
+		t.Fatalf("probe: err = %v, want the upstream error", err)
+	}
+	if _, err := r.LookupHost(ctx, "example.com"); err != ErrCircuitOpen {
+		t.Fatalf("err = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// The probe succeeds and closes the breaker.
+	upstream.SetHost("example.com", "192.0.2.1")
+	clock.Advance(30 * time.Second)
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	r.Remove("example.com")
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		t.Fatalf("lookup after recovery: %v", err)
+	}
+	if n := upstream.Calls("example.com"); n != 5 {
+		t.Errorf("upstream called %d times, want 5", n)
+	}
+}
+
+func TestResolver_CircuitBreakerServesStale(t *testing.T) {
+	clock := newFakeClock()
+	r := &Resolver{Resolver: ttlResolver{ttl: time.Second}, Clock: clock, BreakerThreshold: 1}
+	ctx := context.Background()
+	want, err := r.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Resolver = ttlResolver{BadResolver: BadResolver{choke: true}, ttl: time.Second}
+	for i := 0; i < 3; i++ {
+		clock.Advance(2 * time.Second)
+		addrs, err := r.LookupHost(ctx, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameAddrs(addrs, want) {
+			t.Errorf("addrs = %v, want the cached %v", addrs, want)
+		}
+	}
+	if n := r.Stats().BreakerRejections; n != 2 {
+		t.Errorf("BreakerRejections = %d, want 2", n)
+	}
+}
+
+func TestResolver_CircuitBreakerRateLimitedProbe(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHostError("a.example.com", dnscachetest.Temporary("a.example.com"))
+	upstream.SetHost("b.example.com", "192.0.2.2")
+	clock := newFakeClock()
+	r := &Resolver{
+		Resolver:          upstream,
+		Clock:             clock,
+		BreakerThreshold:  1,
+		RateLimit:         1,
+		RateBurst:         1,
+		RateLimitFailFast: true,
+	}
+	ctx := context.Background()
+	if _, err := r.LookupHost(ctx, "a.example.com"); err == nil || err == ErrCircuitOpen {
+		t.Fatalf("err = %v, want the upstream error", err)
+	}
+
+	// The probe of the half-open breaker is rejected by the rate limiter.
+	clock.Advance(30 * time.Second)
+	if _, err := r.LookupHost(ctx, "b.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupHost(ctx, "a.example.com"); err != ErrRateLimited {
+		t.Fatalf("probe: err = %v, want %v", err, ErrRateLimited)
+	}
+
+	// The next lookup probes again.
+	clock.Advance(time.Second)
+	upstream.SetHost("a.example.com", "192.0.2.1")
+	if _, err := r.LookupHost(ctx, "a.example.com"); err != nil {
+		t.Fatalf("second probe: err = %v, want none", err)
+	}
+}
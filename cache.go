@@ -0,0 +1,495 @@
+package dnscache
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardCount is the number of independently locked partitions of the cache.
+// It must be a power of two.
+const shardCount = 32
+
+// cacheShard holds the entries whose key hashes to it. The entry fields are
+// protected by mu, except used which is only accessed atomically so that
+// cache hits never need the write lock.
+type cacheShard struct {
+	mu      sync.RWMutex
+	entries map[cacheKey]*cacheEntry
+
+	// bytes is the approximate memory used by the entries, see entrySize.
+	bytes int64
+}
+
+// cacheKey identifies a cache entry by record type and name, and by the
+// client subnet of the lookups of ContextWithClientSubnet if any. Using a
+// struct rather than a prefixed string avoids building a new string on
+// every lookup.
+type cacheKey struct {
+	rtype  RecordType
+	name   string
+	subnet string
+}
+
+// String returns the key in its flat form, e.g. "hexample.com" or
+// "hexample.com@192.0.2.0/24", as used for the singleflight group.
+func (k cacheKey) String() string {
+	if k.subnet != "" {
+		return string(byte(k.rtype)) + k.name + "@" + k.subnet
+	}
+	return string(byte(k.rtype)) + k.name
+}
+
+type cacheEntry struct {
+	// rrs are the records served, answer the records of the last answer
+	// from source. They only differ when health checks demoted some of the
+	// addresses, see Resolver.HealthCheckInterval.
+	rrs     []string
+	answer  []string
+	used    atomic.Bool
+	hits    atomic.Uint64
+	expires time.Time
+
+	// revalidating is set while an expired entry is being resolved again in
+	// the background, see ServeExpired.
+	revalidating atomic.Bool
+
+	// size is the approximate memory used by the entry. lastUsed is when
+	// it was last stored or loaded, in Unix nanoseconds, only tracked with
+	// Resolver.MaxMemory.
+	size     int64
+	lastUsed atomic.Int64
+
+	// created is when the entry was first cached and refreshed when its
+	// records were last updated from source.
+	created   time.Time
+	refreshed time.Time
+	source    string
+
+	// upstream is the upstream source got the records from, see
+	// EntryInfo.Upstream.
+	upstream string
+
+	// raw is the answer of source before the transformations of the
+	// resolver, see lookupResult.raw.
+	raw []string
+
+	// lastErr is the error of the last failed update of the entry, cleared
+	// by the next successful one.
+	lastErr error
+
+	// negErr is the cached error of a negative entry, see
+	// Resolver.ReverseNegativeTTL.
+	negErr error
+
+	// pinned entries are never evicted, see Resolver.Pin.
+	pinned bool
+
+	// refreshHits is the value of hits at the last refresh, and
+	// coldCycles the number of refreshes skipped since then, see
+	// Resolver.RefreshHotHits.
+	refreshHits uint64
+	coldCycles  int
+
+	// unchanged is the number of consecutive updates which found the
+	// records unchanged, see Resolver.AdaptiveRefresh.
+	unchanged int
+
+	// idleCycles is the number of consecutive refreshes which found the
+	// entry unused, the first of them at idleSince.
+	idleCycles int
+	idleSince  time.Time
+}
+
+// shardIndex returns the index of the shard responsible for key.
+func shardIndex(key cacheKey) int {
+	// Inlined 32-bit FNV-1a, avoiding the allocation of hash/fnv.
+	h := (uint32(2166136261) ^ uint32(key.rtype)) * 16777619
+	for i := 0; i < len(key.name); i++ {
+		h ^= uint32(key.name[i])
+		h *= 16777619
+	}
+	return int(h & (shardCount - 1))
+}
+
+// shard returns the shard responsible for key.
+func (r *Resolver) shard(key cacheKey) *cacheShard {
+	return &r.shards[shardIndex(key)]
+}
+
+// keysOf returns the keys of name for rtype: the one without client subnet
+// first, then those of the other subnets cached or being looked up, so that
+// the operations on a name apply to the entries of ContextWithClientSubnet
+// too.
+func (r *Resolver) keysOf(rtype RecordType, name string) []cacheKey {
+	keys := []cacheKey{{rtype: rtype, name: name}}
+	if !r.subnets.Load() {
+		return keys
+	}
+	variant := func(key cacheKey) bool {
+		return key.rtype == rtype && key.name == name && key.subnet != ""
+	}
+	seen := make(map[cacheKey]bool)
+	s := r.shard(keys[0])
+	s.mu.RLock()
+	for key := range s.entries {
+		if variant(key) {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	s.mu.RUnlock()
+	r.flightsMu.Lock()
+	for key := range r.flights {
+		if variant(key) && !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	r.flightsMu.Unlock()
+	return keys
+}
+
+// len returns the number of cached entries.
+func (r *Resolver) len() (n int) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		n += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// peek returns the records cached for key without marking the entry used.
+func (r *Resolver) peek(key cacheKey) (rrs []string, found bool) {
+	s := r.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, found := s.entries[key]
+	if !found {
+		return nil, false
+	}
+	return entry.rrs, true
+}
+
+// results returns rrs, to be returned to a caller, or a copy of rrs if
+// CopyResults is set.
+func (r *Resolver) results(rrs []string) []string {
+	if !r.CopyResults || rrs == nil {
+		return rrs
+	}
+	return append([]string(nil), rrs...)
+}
+
+// load returns the records cached for key, marking the entry used, and
+// whether the entry is past its expiry time.
+func (r *Resolver) load(key cacheKey) (rrs []string, expired, found bool) {
+	s := r.shard(key)
+	s.mu.RLock()
+	entry, found := s.entries[key]
+	var expires time.Time
+	if found {
+		rrs = entry.rrs
+		expires = entry.expires
+	}
+	s.mu.RUnlock()
+	if !found {
+		return
+	}
+	if !expires.IsZero() || r.MaxMemory > 0 {
+		now := r.now()
+		expired = !expires.IsZero() && now.After(expires)
+		if r.MaxMemory > 0 {
+			entry.touch(now)
+		}
+	}
+
+	// Only store when the flag changes to avoid bouncing the cache line
+	// between readers of a hot entry.
+	if !entry.used.Load() {
+		entry.used.Store(true)
+	}
+	entry.hits.Add(1)
+	return rrs, expired, true
+}
+
+// store caches lr under key, evicting another entry if MaxEntries is
+// exceeded.
+func (r *Resolver) store(key cacheKey, lr lookupResult, used bool) {
+	var expires time.Time
+	if lr.hasTTL {
+		expires = r.now().Add(r.clampTTL(lr.ttl))
+	}
+	r.storeExpiring(key, lr, expires, used)
+}
+
+// clampTTL returns ttl bounded by MinTTL and MaxTTL.
+func (r *Resolver) clampTTL(ttl time.Duration) time.Duration {
+	if r.MinTTL > 0 && ttl < r.MinTTL {
+		ttl = r.MinTTL
+	}
+	if r.MaxTTL > 0 && ttl > r.MaxTTL {
+		ttl = r.MaxTTL
+	}
+	return ttl
+}
+
+// storeExpiring is like store with an absolute expiry time. A zero expires
+// means the entry has no known expiry.
+func (r *Resolver) storeExpiring(key cacheKey, lr lookupResult, expires time.Time, used bool) {
+	if key.subnet != "" {
+		r.subnets.Store(true)
+	}
+	old, added, stored := r.shard(key).store(key, lr, r.serveOrder(lr.rrs), r.now(), expires, used, r.isPinned)
+	if !stored {
+		return
+	}
+	// The answers for a client subnet are not the addresses of the host for
+	// everyone else.
+	if key.rtype == TypeHost && key.subnet == "" && (r.OnChange != nil || r.Invalidator != nil || r.watching.Load() > 0) && !sameAddrs(old, lr.rrs) {
+		if !added {
+			if r.OnChange != nil {
+				r.OnChange(key.name, old, lr.rrs)
+			}
+			r.publish(HostChanged, key.name, lr.rrs)
+		}
+		r.notifyWatchers(key.name, lr.rrs)
+	}
+	if r.MaxMemory > 0 {
+		r.evictLRU(key)
+	}
+	if !added {
+		return
+	}
+	if r.size.Add(1) <= int64(r.MaxEntries) || r.MaxEntries <= 0 {
+		return
+	}
+	// Start with the shard of the new entry, it is the most likely to be
+	// over its share.
+	start := shardIndex(key)
+	for i := 0; i < shardCount; i++ {
+		if r.shards[(start+i)%shardCount].evictOne(key) {
+			r.size.Add(-1)
+			r.stats.evictions.Add(1)
+			return
+		}
+	}
+}
+
+// remove deletes the entry of key and reports whether there was one.
+func (r *Resolver) remove(key cacheKey) bool {
+	s := r.shard(key)
+	s.mu.Lock()
+	entry, found := s.entries[key]
+	if found {
+		s.deleteLocked(key, entry)
+	}
+	s.mu.Unlock()
+	if found {
+		r.size.Add(-1)
+	}
+	return found
+}
+
+// store caches the records of lr under key at now, serving them as served.
+// A new entry is pinned if pinned reports so. It returns the records
+// replaced and whether a new entry was added instead. Nothing is stored,
+// and stored is false, if the flight of lr was invalidated.
+func (s *cacheShard) store(key cacheKey, lr lookupResult, served []string, now, expires time.Time, used bool, pinned func(cacheKey) bool) (old []string, added, stored bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Checked under the lock, as Remove and Flush invalidate the flights
+	// before deleting the entries.
+	if lr.flight != nil && lr.flight.invalidated.Load() {
+		return nil, false, false
+	}
+	entry, found := s.entries[key]
+	if !found {
+		// Checked under the lock, so that a concurrent Pin either sees
+		// the new entry or is seen by it.
+		entry = &cacheEntry{created: now, pinned: pinned(key)}
+		s.entries[key] = entry
+	}
+	old = entry.answer
+	if found && sameAddrs(old, lr.rrs) {
+		entry.unchanged++
+	} else {
+		entry.unchanged = 0
+	}
+	size := entrySize(key, lr.rrs, served)
+	s.bytes += size - entry.size
+	entry.size = size
+	entry.touch(now)
+	entry.answer = lr.rrs
+	entry.raw = lr.raw
+	entry.rrs = served
+	entry.used.Store(used)
+	entry.expires = expires
+	entry.revalidating.Store(false)
+	entry.refreshed = now
+	entry.source = lr.source
+	entry.upstream = lr.upstream
+	entry.lastErr = lr.err
+	entry.negErr = lr.err
+	return old, !found, true
+}
+
+// setError records err as the last error of the entry of key, if any.
+func (s *cacheShard) setError(key cacheKey, err error) {
+	s.mu.Lock()
+	if entry, found := s.entries[key]; found {
+		entry.lastErr = err
+	}
+	s.mu.Unlock()
+}
+
+// evictOne deletes an entry other than keep among up to lruSamples of the
+// shard, preferring one not used since the last refresh, else the one with
+// the fewest hits, so that evictions do not scan the whole shard. It
+// reports whether an entry was deleted.
+func (s *cacheShard) evictOne(keep cacheKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var (
+		victim      cacheKey
+		victimEntry *cacheEntry
+		sampled     int
+	)
+	// Map iteration order is random, which spreads evictions.
+	for key, entry := range s.entries {
+		if key == keep || entry.pinned {
+			continue
+		}
+		if !entry.used.Load() {
+			victim, victimEntry = key, entry
+			break
+		}
+		if victimEntry == nil || entry.hits.Load() < victimEntry.hits.Load() {
+			victim, victimEntry = key, entry
+		}
+		if sampled++; sampled == lruSamples {
+			break
+		}
+	}
+	if victimEntry == nil {
+		return false
+	}
+	s.deleteLocked(victim, victimEntry)
+	return true
+}
+
+// deleteLocked deletes entry, stored under key. s.mu must be held.
+func (s *cacheShard) deleteLocked(key cacheKey, entry *cacheEntry) {
+	delete(s.entries, key)
+	s.bytes -= entry.size
+}
+
+// refreshItem is an entry to refresh along with its hits since the last
+// refresh.
+type refreshItem struct {
+	key  cacheKey
+	hits uint64
+}
+
+// defaultAdaptiveRefreshMaxCycles is the default of
+// Resolver.AdaptiveRefreshMaxCycles.
+const defaultAdaptiveRefreshMaxCycles = 8
+
+// refreshPolicy selects the entries refreshed by a refresh, see
+// Resolver.UnusedPolicy, RefreshHotHits and RefreshColdEvery. maxCycles is
+// set with Resolver.AdaptiveRefresh only.
+type refreshPolicy struct {
+	keep      func(IdleInfo) bool
+	hotHits   uint64
+	coldEvery int
+	maxCycles int
+}
+
+// due reports whether entry, used since the last refresh, is refreshed by
+// this one, and returns its hits since then, or since its own last refresh
+// with AdaptiveRefresh.
+func (p refreshPolicy) due(entry *cacheEntry) (hits uint64, due bool) {
+	total := entry.hits.Load()
+	hits = total - entry.refreshHits
+	switch {
+	case entry.pinned:
+	case p.maxCycles > 0:
+		if cycles := entry.coldCycles + 1; cycles < p.adaptiveEvery(entry.unchanged, hits, cycles) {
+			entry.coldCycles = cycles
+			return hits, false
+		}
+	case hits < p.hotHits && p.coldEvery > 1:
+		entry.refreshHits = total
+		if entry.coldCycles++; entry.coldCycles < p.coldEvery {
+			return hits, false
+		}
+	}
+	entry.refreshHits = total
+	entry.coldCycles = 0
+	return hits, true
+}
+
+// adaptiveEvery returns how many refreshes it takes to refresh an entry
+// whose records were unchanged by that many updates in a row and which was
+// hit hits times over the last cycles refreshes. An entry whose records
+// just changed is refreshed every time, however cold.
+func (p refreshPolicy) adaptiveEvery(unchanged int, hits uint64, cycles int) int {
+	if unchanged == 0 {
+		return 1
+	}
+	every := p.maxCycles
+	if unchanged < bits.Len(uint(p.maxCycles)) {
+		every = 1 << unchanged
+	}
+	hotHits := p.hotHits
+	if hotHits == 0 {
+		hotHits = 1
+	}
+	if hits >= hotHits*uint64(cycles) {
+		every /= 2
+	} else {
+		every *= 2
+	}
+	if every < 1 {
+		return 1
+	}
+	if every > p.maxCycles {
+		return p.maxCycles
+	}
+	return every
+}
+
+// purgeUnused deletes the entries of s which have not been used since the
+// last refresh, unless policy keeps them at now, appending their keys to
+// purged, and appends the remaining ones to update, except those whose
+// refresh policy skips.
+func (s *cacheShard) purgeUnused(update []refreshItem, purged []cacheKey, policy refreshPolicy, now time.Time) ([]refreshItem, []cacheKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, entry := range s.entries {
+		if entry.used.Load() || entry.pinned {
+			entry.idleCycles = 0
+			hits, due := policy.due(entry)
+			if !due {
+				// Not refreshed, but still accounted as used since
+				// the last refresh only.
+				entry.used.Store(false)
+				continue
+			}
+			update = append(update, refreshItem{key, hits})
+			continue
+		}
+		if entry.idleCycles == 0 {
+			entry.idleSince = now
+		}
+		entry.idleCycles++
+		if policy.keep != nil && policy.keep(IdleInfo{Cycles: entry.idleCycles, Duration: now.Sub(entry.idleSince)}) {
+			update = append(update, refreshItem{key: key})
+			continue
+		}
+		s.deleteLocked(key, entry)
+		purged = append(purged, key)
+	}
+	return update, purged
+}
@@ -0,0 +1,68 @@
+package dnscache
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+)
+
+// canonicalAddrs returns addrs without duplicates, sorted with the IPv4
+// addresses first, each family in numeric order. Addresses are compared in
+// their parsed form, so that e.g. "2001:db8::1" and "2001:DB8:0::1" are
+// duplicates; the first spelling is kept.
+func canonicalAddrs(addrs []string) []string {
+	type parsed struct {
+		addr string
+		ip   net.IP
+	}
+	ips := make([]parsed, 0, len(addrs))
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ips = append(ips, parsed{addr, ip})
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		a, b := ips[i].ip, ips[j].ip
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		if a == nil {
+			return ips[i].addr < ips[j].addr
+		}
+		return bytes.Compare(a, b) < 0
+	})
+	canonical := make([]string, 0, len(ips))
+	for i, p := range ips {
+		if i > 0 {
+			prev := ips[i-1]
+			if p.ip != nil && p.ip.Equal(prev.ip) || p.ip == nil && p.addr == prev.addr {
+				continue
+			}
+		}
+		canonical = append(canonical, p.addr)
+	}
+	return canonical
+}
+
+// canonicalNames returns names without duplicates, sorted. Names differing
+// only by case or a trailing dot are duplicates.
+func canonicalNames(names []string) []string {
+	sorted := append([]string(nil), names...)
+	key := func(name string) string {
+		return strings.ToLower(strings.TrimSuffix(name, "."))
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return key(sorted[i]) < key(sorted[j])
+	})
+	canonical := sorted[:0]
+	for i, name := range sorted {
+		if i > 0 && key(name) == key(sorted[i-1]) {
+			continue
+		}
+		canonical = append(canonical, name)
+	}
+	return canonical
+}
@@ -0,0 +1,46 @@
+package dnscache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalAddrs(t *testing.T) {
+	got := canonicalAddrs([]string{"2001:db8::2", "192.0.2.10", "192.0.2.9", "2001:DB8:0::2", "192.0.2.10", "2001:db8::1"})
+	want := []string{"192.0.2.9", "192.0.2.10", "2001:db8::1", "2001:db8::2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("canonicalAddrs() = %v, want %v", got, want)
+	}
+}
+
+func TestCanonicalNames(t *testing.T) {
+	got := canonicalNames([]string{"b.example.com.", "A.example.com.", "a.example.com", "b.example.com."})
+	want := []string{"A.example.com.", "b.example.com."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("canonicalNames() = %v, want %v", got, want)
+	}
+}
+
+func TestResolver_Canonicalize(t *testing.T) {
+	upstream := &switchResolver{Resolver: addrsResolver{addrs: []string{"192.0.2.2", "192.0.2.1", "192.0.2.2"}}}
+	changes := 0
+	r := &Resolver{
+		Resolver:     upstream,
+		Canonicalize: true,
+		OnChange:     func(host string, old, new []string) { changes++ },
+	}
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.0.2.1", "192.0.2.2"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addrs = %v, want %v", addrs, want)
+	}
+
+	upstream.Resolver = addrsResolver{addrs: []string{"192.0.2.1", "192.0.2.2", "192.0.2.1"}}
+	r.Refresh()
+	if changes != 0 {
+		t.Errorf("OnChange called %d times for the same set of addresses", changes)
+	}
+}
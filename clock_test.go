@@ -0,0 +1,32 @@
+package dnscache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolver_Clock(t *testing.T) {
+	clock := newFakeClock()
+	f := &slowResolver{}
+	r := &Resolver{Resolver: f, RefreshInterval: time.Minute, Clock: clock}
+	defer r.Close()
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Time does not move on its own, however long the test sleeps.
+	time.Sleep(10 * time.Millisecond)
+	if calls := atomic.LoadInt32(&f.calls); calls != 1 {
+		t.Fatalf("upstream called %d times before the refresh interval, want 1", calls)
+	}
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&f.calls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 2 {
+		t.Errorf("upstream called %d times after the refresh interval, want 2", calls)
+	}
+}
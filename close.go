@@ -0,0 +1,84 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is returned by the lookups of a closed Resolver.
+var ErrClosed = errors.New("dnscache: resolver closed")
+
+// Close stops the resolver: the background work started for
+// RefreshInterval, ExpiredGCInterval and HealthCheckInterval stops, a
+// refresh in progress is aborted, the upstream lookups in flight are
+// cancelled and forgotten, the channels returned by Watch are closed, and
+// lookups fail with ErrClosed from then on, including those which were
+// waiting on an upstream lookup. Close returns once the background
+// goroutines, such as revalidations and shadow lookups, and the upstream
+// lookups have returned, so upstream resolvers must honor the cancellation
+// of their context.
+func (r *Resolver) Close() error {
+	r.once.Do(r.init)
+	r.closeOnce.Do(func() {
+		r.closed.Store(true)
+		r.cancel()
+		r.closeWatchers()
+	})
+	r.loops.Wait()
+
+	r.flightsMu.Lock()
+	defer r.flightsMu.Unlock()
+	for key := range r.flights {
+		r.group.Forget(key.String())
+	}
+	for r.running > 0 {
+		r.flightsDone.Wait()
+	}
+	return nil
+}
+
+// endRunningLocked accounts for the end of an upstream lookup. flightsMu
+// must be held.
+func (r *Resolver) endRunningLocked() {
+	if r.running--; r.running == 0 {
+		r.flightsDone.Broadcast()
+	}
+}
+
+// goTracked runs fn in a new goroutine which Close waits for, unless the
+// resolver is closed, and reports whether it did.
+func (r *Resolver) goTracked(fn func()) bool {
+	r.flightsMu.Lock()
+	defer r.flightsMu.Unlock()
+	if r.closed.Load() {
+		return false
+	}
+	r.running++
+	go func() {
+		defer func() {
+			r.flightsMu.Lock()
+			r.endRunningLocked()
+			r.flightsMu.Unlock()
+		}()
+		fn()
+	}()
+	return true
+}
+
+// cancelOnClose returns a copy of parent which is also cancelled when the
+// resolver is closed.
+func (r *Resolver) cancelOnClose(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel()
+	}
+}
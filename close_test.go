@@ -0,0 +1,196 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResolver_Close(t *testing.T) {
+	for _, propagate := range []bool{false, true} {
+		r := &Resolver{Resolver: blockingResolver{}, PropagateContext: propagate}
+		errs := make(chan error, 1)
+		go func() {
+			_, err := r.LookupHost(context.Background(), "example.com")
+			errs <- err
+		}()
+		for r.Stats().Lookups == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			_ = r.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatalf("PropagateContext = %v: Close did not cancel the upstream lookup", propagate)
+		}
+		if err := <-errs; !errors.Is(err, ErrClosed) {
+			t.Errorf("PropagateContext = %v: pending lookup err = %v, want %v", propagate, err, ErrClosed)
+		}
+		r.flightsMu.Lock()
+		running := r.running
+		r.flightsMu.Unlock()
+		if running != 0 {
+			t.Errorf("PropagateContext = %v: %d upstream lookups running after Close", propagate, running)
+		}
+	}
+}
+
+func TestResolver_LookupAfterClose(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != ErrClosed {
+		t.Errorf("cached LookupHost err = %v, want %v", err, ErrClosed)
+	}
+	if _, err := r.LookupAddr(context.Background(), "192.0.2.1"); err != ErrClosed {
+		t.Errorf("LookupAddr err = %v, want %v", err, ErrClosed)
+	}
+	if _, err := r.ForceRefresh(context.Background(), "example.com"); err != ErrClosed {
+		t.Errorf("ForceRefresh err = %v, want %v", err, ErrClosed)
+	}
+	if report := r.RefreshWithReport(); len(report.Refreshed) != 0 {
+		t.Errorf("refresh after Close refreshed %v", report.Refreshed)
+	}
+}
+
+func TestResolver_CloseWatchers(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}}
+	c, err := r.Watch(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-c:
+			if !ok {
+				if n := r.watching.Load(); n != 0 {
+					t.Errorf("%d watchers after Close, want 0", n)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Close did not close the Watch channel")
+		}
+	}
+}
+
+func TestResolver_CloseBackground(t *testing.T) {
+	results := make(chan ShadowResult, 1)
+	r := &Resolver{
+		Resolver:         BadResolver{},
+		ShadowResolver:   blockingResolver{},
+		ShadowSampleRate: 1,
+		OnShadowResult:   func(res ShadowResult) { results <- res },
+	}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not cancel the shadow lookup")
+	}
+	select {
+	case res := <-results:
+		if !errors.Is(res.ShadowErr, context.Canceled) {
+			t.Errorf("shadow lookup err = %v, want %v", res.ShadowErr, context.Canceled)
+		}
+	default:
+		t.Error("Close returned before the shadow lookup")
+	}
+}
+
+func TestResolver_CloseRefreshSpread(t *testing.T) {
+	clock := newFakeClock()
+	r := &Resolver{Resolver: BadResolver{}, Clock: clock, RefreshSpread: time.Hour}
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		r.Refresh()
+		close(done)
+	}()
+	// Wait for the refresh to wait for the slot of its first entry.
+	for {
+		clock.mu.Lock()
+		waiting := len(clock.waiters) > 0
+		clock.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_ = r.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not abort the wait of Refresh between entries")
+	}
+}
+
+func TestResolver_CloseQueuedLookup(t *testing.T) {
+	r := &Resolver{Resolver: blockingResolver{}, MaxConcurrentLookups: 1, PropagateContext: true}
+	defer r.Close()
+	go func() {
+		_, _ = r.LookupHost(context.Background(), "a.example.com")
+	}()
+	for r.Stats().Lookups == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.LookupHost(ctx, "b.example.com")
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("queued lookup err = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued lookup did not give up once its context was done")
+	}
+	// The upstream lookup of b.example.com stops waiting for its turn too.
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.flightsMu.Lock()
+		running := r.running
+		r.flightsMu.Unlock()
+		if running == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d upstream lookups running, want the unqueued one only", running)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,50 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTooManyLookups is returned for upstream lookups which waited longer
+// than Resolver.LookupQueueTimeout for their turn under
+// MaxConcurrentLookups.
+var ErrTooManyLookups = errors.New("dnscache: too many concurrent upstream lookups")
+
+// acquireLookup waits for an upstream lookup slot under MaxConcurrentLookups
+// and returns the function releasing it, or the error of ctx if it is done
+// first.
+func (r *Resolver) acquireLookup(ctx context.Context) (release func(), err error) {
+	if r.lookupSem == nil {
+		return func() {}, nil
+	}
+	select {
+	case r.lookupSem <- struct{}{}:
+		return r.releaseLookup, nil
+	default:
+	}
+
+	r.stats.lookupQueueWaits.Add(1)
+	if r.LookupQueueTimeout <= 0 {
+		select {
+		case r.lookupSem <- struct{}{}:
+			return r.releaseLookup, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	t := r.clock().NewTimer(r.LookupQueueTimeout)
+	defer t.Stop()
+	select {
+	case r.lookupSem <- struct{}{}:
+		return r.releaseLookup, nil
+	case <-t.C():
+		r.stats.lookupQueueTimeouts.Add(1)
+		return nil, ErrTooManyLookups
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *Resolver) releaseLookup() {
+	<-r.lookupSem
+}
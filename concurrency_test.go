@@ -0,0 +1,73 @@
+package dnscache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrentResolver records the largest number of lookups running at once.
+type concurrentResolver struct {
+	BadResolver
+	delay         time.Duration
+	running, peak int32
+}
+
+func (r *concurrentResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	n := atomic.AddInt32(&r.running, 1)
+	defer atomic.AddInt32(&r.running, -1)
+	for {
+		peak := atomic.LoadInt32(&r.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&r.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(r.delay)
+	return r.BadResolver.LookupHost(ctx, host)
+}
+
+func TestResolver_MaxConcurrentLookups(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueTimeout time.Duration
+		wantErrors   int
+	}{
+		{"wait", 0, 0},
+		{"timeout", 5 * time.Millisecond, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &concurrentResolver{delay: 20 * time.Millisecond}
+			r := &Resolver{Resolver: upstream, MaxConcurrentLookups: 2, LookupQueueTimeout: tt.queueTimeout}
+			var (
+				wg     sync.WaitGroup
+				errors int32
+			)
+			for _, host := range benchmarkHosts(6) {
+				wg.Add(1)
+				go func(host string) {
+					defer wg.Done()
+					if _, err := r.LookupHost(context.Background(), host); err != nil {
+						if err != ErrTooManyLookups {
+							t.Error(err)
+						}
+						atomic.AddInt32(&errors, 1)
+					}
+				}(host)
+			}
+			wg.Wait()
+
+			if peak := atomic.LoadInt32(&upstream.peak); peak != 2 {
+				t.Errorf("%d lookups ran at once, want 2", peak)
+			}
+			if n := int(atomic.LoadInt32(&errors)); n != tt.wantErrors {
+				t.Errorf("%d lookups failed, want %d", n, tt.wantErrors)
+			}
+			if n := r.Stats().LookupQueueWaits; n != 4 {
+				t.Errorf("LookupQueueWaits = %d, want 4", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package dnscache
+
+import (
+	"net"
+	"time"
+)
+
+// resolverBox holds the upstream resolver set with SetResolver, so that it
+// can be swapped atomically whatever its type.
+type resolverBox struct {
+	resolver DNSResolver
+}
+
+// SetResolver replaces the upstream resolver, e.g. after the DNS servers
+// changed with a DHCP renewal or a configuration reload. Unlike assigning
+// the Resolver field, it is safe to call concurrently with lookups:
+// upstream lookups started afterwards use resolver, while those in flight
+// complete with the previous one. Once called, the Resolver field is
+// ignored. A nil resolver selects the system resolver. Cached entries are
+// kept; call Flush to resolve them again through resolver right away.
+func (r *Resolver) SetResolver(resolver DNSResolver) {
+	r.upstreamSet.Store(&resolverBox{resolver: resolver})
+}
+
+// SetTimeout replaces the timeout of upstream lookups, like assigning the
+// Timeout field but safe to call concurrently with lookups. Once called,
+// the Timeout field is ignored.
+func (r *Resolver) SetTimeout(d time.Duration) {
+	r.timeoutSet.Store(&d)
+}
+
+// upstream returns the upstream resolver.
+func (r *Resolver) upstream() DNSResolver {
+	resolver := r.Resolver
+	if box := r.upstreamSet.Load(); box != nil {
+		resolver = box.resolver
+	}
+	if resolver == nil {
+		return r.systemResolver()
+	}
+	return resolver
+}
+
+// systemResolver returns the resolver used when none is set.
+func (r *Resolver) systemResolver() DNSResolver {
+	if r.system != nil {
+		return r.system
+	}
+	return defaultResolver
+}
+
+// newSystemResolver returns the resolver configured by PreferGo,
+// StrictErrors and Dial, or nil if they are not set.
+func (r *Resolver) newSystemResolver() *defaultResolverWithTrace {
+	if !r.PreferGo && !r.StrictErrors && r.Dial == nil {
+		return nil
+	}
+	return &defaultResolverWithTrace{resolver: &net.Resolver{
+		PreferGo:     r.PreferGo,
+		StrictErrors: r.StrictErrors,
+		Dial:         r.Dial,
+	}}
+}
+
+// timeout returns the timeout of upstream lookups.
+func (r *Resolver) timeout() time.Duration {
+	if d := r.timeoutSet.Load(); d != nil {
+		return *d
+	}
+	return r.Timeout
+}
@@ -0,0 +1,76 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_SetResolver(t *testing.T) {
+	first := dnscachetest.NewResolver()
+	first.SetHost("example.com", "192.0.2.1")
+	second := dnscachetest.NewResolver()
+	second.SetHost("example.com", "192.0.2.2")
+	r := &Resolver{Resolver: first}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = r.LookupHostWith(ctx, "example.com", NoCache)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		r.SetResolver(second)
+		r.SetTimeout(time.Second)
+	}()
+	wg.Wait()
+
+	addrs, err := r.LookupHostWith(ctx, "example.com", NoCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.2" {
+		t.Errorf("addrs = %v, want the answer of the new resolver", addrs)
+	}
+	if d := r.timeout(); d != time.Second {
+		t.Errorf("timeout = %v, want 1s", d)
+	}
+}
+
+func TestResolver_Dial(t *testing.T) {
+	server := startTestDNSServer(t, testRawHandler)
+	var dialed atomic.Int32
+	r, err := New(context.Background(), WithPreferGo(), WithStrictErrors(),
+		WithDial(func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", server)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	addrs, err := r.LookupHost(context.Background(), "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameAddrs(addrs, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}) {
+		t.Errorf("addrs = %v, want the addresses of the test server", addrs)
+	}
+	if dialed.Load() == 0 {
+		t.Error("Dial was not used")
+	}
+	if source := r.Entries()[0].Source; source != "system" {
+		t.Errorf("Source = %q, want system", source)
+	}
+}
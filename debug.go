@@ -0,0 +1,175 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DebugHandler returns an http.Handler exposing the cache for operators,
+// meant to be mounted at e.g. /debug/dnscache/:
+//
+//	GET  /             entries and stats, as JSON or with ?format=html as HTML
+//	POST /flush        deletes every entry, or only the one of ?host=
+//	POST /refresh      resolves ?host= upstream again, see ForceRefresh
+//
+// The handler exposes host names and addresses and performs upstream
+// lookups, so it should not be reachable by untrusted clients.
+func (r *Resolver) DebugHandler() http.Handler {
+	return http.HandlerFunc(r.serveDebug)
+}
+
+// debugEntry is the rendering of an EntryInfo, with the error as a string
+// so that it survives JSON encoding.
+type debugEntry struct {
+	Name        string    `json:"name"`
+	Reverse     bool      `json:"reverse,omitempty"`
+	Network     string    `json:"network,omitempty"`
+	Type        string    `json:"type"`
+	Subnet      string    `json:"clientSubnet,omitempty"`
+	Pinned      bool      `json:"pinned,omitempty"`
+	Records     []string  `json:"records"`
+	Age         string    `json:"age"`
+	LastRefresh time.Time `json:"lastRefresh"`
+	Expires     time.Time `json:"expires"`
+	LastError   string    `json:"lastError,omitempty"`
+	Hits        uint64    `json:"hits"`
+	Used        bool      `json:"used"`
+	Source      string    `json:"source,omitempty"`
+	Upstream    string    `json:"upstream,omitempty"`
+}
+
+type debugPage struct {
+	Stats   Stats        `json:"stats"`
+	Entries []debugEntry `json:"entries"`
+}
+
+func (r *Resolver) serveDebug(w http.ResponseWriter, req *http.Request) {
+	switch path.Base(req.URL.Path) {
+	case "flush":
+		if !debugPost(w, req) {
+			return
+		}
+		if host := req.FormValue("host"); host != "" {
+			r.Remove(host)
+		} else {
+			r.Flush()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "refresh":
+		if !debugPost(w, req) {
+			return
+		}
+		host := req.FormValue("host")
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		addrs, err := r.ForceRefresh(req.Context(), host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, addrs)
+	default:
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		page := r.debugPage()
+		if req.FormValue("format") == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = debugTemplate.Execute(w, page)
+			return
+		}
+		writeJSON(w, page)
+	}
+}
+
+// debugPost reports whether req is a POST, replying with an error if not.
+func debugPost(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method == http.MethodPost {
+		return true
+	}
+	w.Header().Set("Allow", "POST")
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func (r *Resolver) debugPage() debugPage {
+	infos := r.Entries()
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Reverse != infos[j].Reverse {
+			return !infos[i].Reverse
+		}
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].Type < infos[j].Type
+	})
+	page := debugPage{Stats: r.Stats(), Entries: make([]debugEntry, len(infos))}
+	for i, info := range infos {
+		e := debugEntry{
+			Name:        info.Name,
+			Reverse:     info.Reverse,
+			Network:     info.Network,
+			Type:        info.Type.String(),
+			Subnet:      info.ClientSubnet,
+			Pinned:      !info.Reverse && r.isPinned(cacheKey{rtype: TypeHost, name: info.Name}),
+			Records:     info.Records,
+			Age:         info.Age.Truncate(time.Millisecond).String(),
+			LastRefresh: info.LastRefresh,
+			Expires:     info.Expires,
+			Hits:        info.Hits,
+			Used:        info.Used,
+			Source:      info.Source,
+			Upstream:    info.Upstream,
+		}
+		if info.LastError != nil {
+			e.LastError = info.LastError.Error()
+		}
+		page.Entries[i] = e
+	}
+	return page
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+var debugTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>dnscache</title></head>
+<body>
+<h1>Stats</h1>
+<table>
+<tr><td>Hits</td><td>{{.Stats.Hits}}</td></tr>
+<tr><td>Negative hits</td><td>{{.Stats.NegativeHits}}</td></tr>
+<tr><td>Misses</td><td>{{.Stats.Misses}}</td></tr>
+<tr><td>Lookups</td><td>{{.Stats.Lookups}}</td></tr>
+<tr><td>Lookup errors</td><td>{{.Stats.LookupErrors}}</td></tr>
+<tr><td>Retries</td><td>{{.Stats.Retries}}</td></tr>
+<tr><td>Timeouts</td><td>{{.Stats.Timeouts}}</td></tr>
+<tr><td>Evictions</td><td>{{.Stats.Evictions}}</td></tr>
+<tr><td>Refresh errors</td><td>{{.Stats.RefreshErrors}}</td></tr>
+<tr><td>Rate limited</td><td>{{.Stats.RateLimited}}</td></tr>
+{{range $name, $n := .Stats.UpstreamAnswers}}<tr><td>Answers of {{$name}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<h1>Entries</h1>
+<table>
+<tr><th>Name</th><th>Type</th><th>Records</th><th>Age</th><th>Expires</th><th>Hits</th><th>Source</th><th>Last error</th></tr>
+{{range .Entries}}<tr><td>{{.Name}}{{if .Reverse}} (reverse){{end}}{{with .Network}} ({{.}}){{end}}{{with .Subnet}} (for {{.}}){{end}}{{if .Pinned}} (pinned){{end}}</td><td>{{.Type}}</td><td>{{join .Records ", "}}</td><td>{{.Age}}</td><td>{{if not .Expires.IsZero}}{{.Expires.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td><td>{{.Hits}}</td><td>{{.Source}}{{if and .Upstream (ne .Upstream .Source)}} via {{.Upstream}}{{end}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
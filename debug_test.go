@@ -0,0 +1,90 @@
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolver_DebugHandler(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.StripPrefix("/debug/dnscache", r.DebugHandler()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/dnscache/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page debugPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Name != "example.com" {
+		t.Fatalf("entries = %+v, want example.com", page.Entries)
+	}
+	if page.Stats.Misses != 1 {
+		t.Errorf("Stats.Misses = %d, want 1", page.Stats.Misses)
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/dnscache/?format=html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(body.String(), "<td>example.com</td>") {
+		t.Errorf("HTML page does not list example.com:\n%s", body.String())
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/dnscache/flush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET flush: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	resp, err = http.Post(srv.URL+"/debug/dnscache/refresh?host=other.example.com", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST refresh: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if _, ok := r.Peek("other.example.com"); !ok {
+		t.Error("refreshed host is not cached")
+	}
+
+	resp, err = http.Post(srv.URL+"/debug/dnscache/flush?host=example.com", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, ok := r.Peek("example.com"); ok {
+		t.Error("flushed host is still cached")
+	}
+	if _, ok := r.Peek("other.example.com"); !ok {
+		t.Error("flushing one host deleted another")
+	}
+
+	resp, err = http.Post(srv.URL+"/debug/dnscache/flush", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := r.len(); n != 0 {
+		t.Errorf("cache holds %d entries after flush, want 0", n)
+	}
+}
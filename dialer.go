@@ -0,0 +1,83 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Dialer connects to addresses whose host is resolved through Resolver, so
+// that anything accepting a DialContext function, such as http.Transport,
+// gets cached resolution. net.Dialer.Resolver only accepts a *net.Resolver
+// and cannot use the cache directly.
+type Dialer struct {
+	// Resolver resolves the host of the dialed addresses. It must not be
+	// nil.
+	Resolver *Resolver
+
+	// Dialer connects to the resolved addresses. If nil, a zero net.Dialer
+	// is used.
+	Dialer *net.Dialer
+}
+
+// Dial connects to address on the named network.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address on the named network. The host of address
+// is resolved through the cache and its addresses are tried in turn until
+// one connection succeeds. Addresses of the wrong family for a tcp4, tcp6,
+// udp4 or udp6 network are skipped. The outcome of each attempt is reported
+// to the Resolver, see Resolver.OrderByLatency.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	ips, err := d.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	for _, ip := range ips {
+		if !matchesNetwork(network, ip) {
+			continue
+		}
+		start := d.Resolver.now()
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if ctx.Err() == nil {
+			d.Resolver.ReportResult(host, ip, err, d.Resolver.now().Sub(start))
+		}
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	return nil, err
+}
+
+// matchesNetwork reports whether ip, an IPv4 or IPv6 address, can be dialed
+// on network.
+func matchesNetwork(network, ip string) bool {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return !strings.Contains(ip, ":")
+	case strings.HasSuffix(network, "6"):
+		return strings.Contains(ip, ":")
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDialer_DialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// The first address refuses connections and the IPv6 one is skipped.
+	d := &Dialer{Resolver: &Resolver{
+		Resolver: addrsResolver{addrs: []string{"127.0.0.2", "::1", "127.0.0.1"}},
+	}}
+	conn, err := d.DialContext(context.Background(), "tcp4", net.JoinHostPort("example.com", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != ln.Addr().String() {
+		t.Errorf("connected to %s, want %s", got, ln.Addr())
+	}
+
+	d.Resolver = &Resolver{Resolver: addrsResolver{addrs: []string{"::1"}}}
+	if _, err := d.DialContext(context.Background(), "tcp4", net.JoinHostPort("example.com", port)); err == nil {
+		t.Error("DialContext succeeded without an IPv4 address")
+	}
+}
@@ -0,0 +1,63 @@
+package dnscache
+
+import "net"
+
+// wellKnownDNS64Prefix is the NAT64 prefix of RFC 6052, 64:ff9b::/96.
+var wellKnownDNS64Prefix = &net.IPNet{
+	IP:   net.IP{0, 0x64, 0xff, 0x9b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	Mask: net.CIDRMask(96, 128),
+}
+
+// synthesizeDNS64 returns addrs preceded by the IPv6 addresses synthesized
+// from its IPv4 addresses, unless addrs already holds an IPv6 address.
+func (r *Resolver) synthesizeDNS64(addrs []string) []string {
+	var v4 []net.IP
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() == nil {
+			return addrs
+		}
+		v4 = append(v4, ip.To4())
+	}
+	if len(v4) == 0 {
+		return addrs
+	}
+	prefix := r.DNS64Prefix
+	if prefix == nil {
+		prefix = wellKnownDNS64Prefix
+	}
+
+	synthesized := make([]string, 0, len(v4)+len(addrs))
+	for _, ip := range v4 {
+		ip6, ok := embedIPv4(prefix, ip)
+		if !ok {
+			return addrs
+		}
+		synthesized = append(synthesized, ip6.String())
+	}
+	return append(synthesized, addrs...)
+}
+
+// embedIPv4 returns the IPv6 address embedding ip in prefix as specified by
+// RFC 6052 section 2.2, skipping the reserved bits 64 to 71. It reports
+// false if the prefix length is not one allowed by the RFC.
+func embedIPv4(prefix *net.IPNet, ip net.IP) (net.IP, bool) {
+	ones, bits := prefix.Mask.Size()
+	if bits != 128 || ones%8 != 0 || ones < 32 || ones > 96 || ones == 72 || ones == 80 || ones == 88 {
+		return nil, false
+	}
+	out := make(net.IP, net.IPv6len)
+	copy(out, prefix.IP.To16()[:ones/8])
+	j := ones / 8
+	for _, b := range ip.To4() {
+		if j == 8 {
+			j++
+		}
+		out[j] = b
+		j++
+	}
+	return out, true
+}
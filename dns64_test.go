@@ -0,0 +1,59 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestEmbedIPv4(t *testing.T) {
+	// Examples of RFC 6052 section 2.4.
+	ip := net.ParseIP("192.0.2.33")
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{"64:ff9b::/96", "64:ff9b::192.0.2.33"},
+	}
+	for _, tt := range tests {
+		_, prefix, err := net.ParseCIDR(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := embedIPv4(prefix, ip)
+		if !ok || !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("embedIPv4(%s) = %v, %v, want %s", tt.prefix, got, ok, tt.want)
+		}
+	}
+	_, invalid, _ := net.ParseCIDR("2001:db8::/80")
+	if _, ok := embedIPv4(invalid, ip); ok {
+		t.Error("embedIPv4 accepted a /80 prefix")
+	}
+}
+
+func TestResolver_DNS64(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		want  []string
+	}{
+		{[]string{"192.0.2.1"}, []string{"64:ff9b::c000:201", "192.0.2.1"}},
+		{[]string{"192.0.2.1", "2001:db8::1"}, []string{"192.0.2.1", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		r := &Resolver{Resolver: addrsResolver{addrs: tt.addrs}, DNS64: true}
+		got, err := r.LookupHost(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupHost with %v = %v, want %v", tt.addrs, got, tt.want)
+		}
+	}
+}
@@ -2,9 +2,16 @@ package dnscache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
 	"net"
 	"net/http/httptrace"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/singleflight"
@@ -15,168 +22,1135 @@ type DNSResolver interface {
 	LookupAddr(ctx context.Context, addr string) (names []string, err error)
 }
 
+// TTLResolver is an optional interface a DNSResolver can implement to report
+// the time-to-live of the records it returns. When the configured Resolver
+// implements it, cache entries remember when their records expire.
+type TTLResolver interface {
+	LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error)
+	LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error)
+}
+
+// NoTTL is the TTL reported by the resolvers made of others, such as
+// SplitResolver, for the answers of upstreams which do not implement
+// TTLResolver. Unlike a TTL of zero, which expires the entry right away,
+// the entry then has no expiry, as if the resolver did not implement
+// TTLResolver itself.
+const NoTTL time.Duration = -1
+
 type Resolver struct {
-	// Timeout defines the maximum allowed time allowed for a lookup.
+	// Timeout defines the maximum allowed time allowed for a lookup. Use
+	// SetTimeout to change it once the resolver is in use.
 	Timeout time.Duration
 
 	// Resolver is used to perform actual DNS lookup. If nil,
-	// net.DefaultResolver is used instead.
+	// net.DefaultResolver is used instead. Use SetResolver to change it
+	// once the resolver is in use.
 	Resolver DNSResolver
 
-	once  sync.Once
-	mu    sync.RWMutex
-	cache map[string]*cacheEntry
+	// PreferGo, StrictErrors and Dial configure the net.Resolver used in
+	// place of net.DefaultResolver when Resolver is nil, as the fields of
+	// the same name of net.Resolver: PreferGo selects the Go resolver over
+	// the one of the operating system, StrictErrors fails lookups on
+	// temporary errors of a single query instead of returning partial
+	// results, and Dial, used by the Go resolver only, connects to the
+	// nameservers, e.g. to force a specific address.
+	PreferGo     bool
+	StrictErrors bool
+	Dial         func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// PropagateContext makes upstream lookups run with a context derived
+	// from the one of the caller, so that its deadline, cancellation and
+	// values apply. As concurrent lookups of a name share a single upstream
+	// lookup, cancelling the caller which started it fails it for all of
+	// them. If false, upstream lookups are detached from the caller context
+	// and only bounded by Timeout.
+	PropagateContext bool
+
+	// ForgetAfter controls when an in-flight upstream lookup is forgotten
+	// after a caller waiting on it hit its context deadline. Forgetting makes
+	// the next caller start a new upstream lookup instead of joining the
+	// pending one. If zero, the lookup is forgotten on every deadline. If
+	// positive, it is only forgotten once it has been running for at least
+	// ForgetAfter. If negative, pending lookups are never forgotten.
+	ForgetAfter time.Duration
+
+	// RefreshInterval, if positive, makes the resolver call Refresh in the
+	// background at that interval, starting with its first use. Call Close
+	// to stop it.
+	RefreshInterval time.Duration
+
+	// RefreshSpread, if positive, spreads the upstream lookups of a refresh
+	// over that duration, each entry being refreshed at a random moment of
+	// the window, instead of issuing them back to back. It should be shorter
+	// than RefreshInterval.
+	RefreshSpread time.Duration
+
+	// RefreshConcurrency is the number of entries refreshed in parallel,
+	// keeping the duration of a refresh bounded for large caches. If zero,
+	// entries are refreshed one at a time.
+	RefreshConcurrency int
+
+	// RefreshHotHits, if positive, is the number of hits since the last
+	// refresh from which an entry is hot. Hot entries are refreshed first;
+	// the others, cold, are refreshed only every RefreshColdEvery
+	// refreshes, so that the upstream query budget goes to hot names.
+	RefreshHotHits uint64
+
+	// RefreshColdEvery is how many refreshes it takes to refresh a cold
+	// entry once. If zero or one, cold entries are refreshed every time,
+	// after the hot ones.
+	RefreshColdEvery int
+
+	// AdaptiveRefresh makes each entry refreshed at its own pace instead,
+	// every 1 to AdaptiveRefreshMaxCycles refreshes, so that upstream
+	// queries go where they are worth it. An entry is refreshed half as
+	// often after every refresh finding its records unchanged, down to
+	// every AdaptiveRefreshMaxCycles refreshes, and every time again as
+	// soon as they change. Hot entries, hit at least RefreshHotHits times
+	// per refresh on average, or once if zero, are refreshed twice as often
+	// as cold ones. RefreshColdEvery is ignored and pinned entries are
+	// refreshed every time.
+	AdaptiveRefresh bool
+
+	// AdaptiveRefreshMaxCycles is the number of refreshes after which an
+	// entry is refreshed at the latest with AdaptiveRefresh. If zero, 8 is
+	// used.
+	AdaptiveRefreshMaxCycles int
+
+	// UnusedPolicy decides whether Refresh keeps, and refreshes, an entry
+	// which has not been used since the previous Refresh. If nil, such
+	// entries are deleted. See KeepUnusedCycles, KeepUnusedFor and
+	// NeverEvictUnused.
+	UnusedPolicy func(IdleInfo) bool
+
+	// MaxEntries bounds the number of cached entries. When a new entry
+	// exceeds it, another one is evicted, preferring entries not used since
+	// the last refresh. If zero or negative, the cache is unbounded.
+	MaxEntries int
+
+	// MaxMemory bounds the approximate memory, in bytes, used by the cached
+	// entries, counting their names and records plus a fixed overhead.
+	// When a store exceeds it, the least recently used entries are
+	// evicted, as approximated by sampling. If zero or negative, memory is
+	// not bounded.
+	MaxMemory int64
+
+	// HealthCheckInterval, if positive, enables active health checks of the
+	// cached addresses: at that interval, every address cached for a host
+	// is dialed over TCP on HealthCheckPort. Addresses which cannot be
+	// reached are served last, or not at all with HealthCheckPrune, until
+	// a later check succeeds.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckPort is the TCP port dialed by health checks. If zero, 80
+	// is used.
+	HealthCheckPort int
+
+	// HealthCheckTimeout bounds each health check dial. If zero, 1s is
+	// used.
+	HealthCheckTimeout time.Duration
+
+	// HealthCheckPrune removes unreachable addresses from the answers
+	// instead of serving them last. If every address of a host is
+	// unreachable, all of them are served.
+	HealthCheckPrune bool
+
+	// OrderByLatency serves the addresses of hosts sorted by the dial
+	// latency and failures reported through ReportResult, or measured by a
+	// Dialer, so that the fastest and healthiest addresses come first.
+	OrderByLatency bool
+
+	// Canonicalize removes the duplicates from upstream answers and sorts
+	// them before caching, the IPv4 addresses first and each family in
+	// numeric order, names alphabetically, so that answers listing the same
+	// records differently are identical. This keeps OnChange, Watch and
+	// Invalidator from reporting spurious changes and the order of the
+	// addresses stable across refreshes. AddrOrder and the other orderings
+	// apply afterwards.
+	Canonicalize bool
+
+	// AddrOrder is the order in which the IPv4 and IPv6 addresses of a host
+	// are served. By default, OrderUpstream, the order of the upstream
+	// resolver is kept, which for the system resolver is the one of RFC
+	// 6724.
+	AddrOrder AddrOrder
+
+	// MaxAddrsPerEntry, if positive, is the maximum number of addresses
+	// cached and returned for a host, bounding the memory of hosts with
+	// dozens of records when dialers only try the first few. AddrSubset
+	// selects the addresses kept.
+	MaxAddrsPerEntry int
+
+	// AddrSubset selects the addresses kept under MaxAddrsPerEntry. The
+	// default is KeepFirst.
+	AddrSubset AddrSubset
+
+	// Shuffler, if set, reorders the addresses returned by every lookup of
+	// a host, e.g. randomly with a RandomShuffler to spread connections
+	// over them, or by a consistent hash or zone-aware policy. The order
+	// given by AddrOrder, health checks and OrderByLatency is the input of
+	// the shuffle. If nil, the cached order is returned.
+	Shuffler Shuffler
+
+	// CopyResults makes lookups and Peek return copies of the cached
+	// records, which callers may then sort or truncate. By default the
+	// returned slices are shared with the cache and the other callers and
+	// must not be modified: this keeps cache hits free of allocations,
+	// while the copy adds one allocation and about half the cost of a hit,
+	// see BenchmarkResolver_LookupHostCopy.
+	CopyResults bool
+
+	// DNS64 synthesizes IPv6 addresses embedding the IPv4 addresses of
+	// hosts without any IPv6 address, as described in RFC 6147, so that
+	// clients on IPv6-only networks can reach them through NAT64. The
+	// synthesized addresses are served before the IPv4 ones.
+	DNS64 bool
+
+	// DNS64Prefix is the NAT64 prefix used by DNS64, of one of the lengths
+	// defined by RFC 6052: 32, 40, 48, 56, 64 or 96 bits. If nil, the
+	// well-known prefix 64:ff9b::/96 is used.
+	DNS64Prefix *net.IPNet
+
+	// ShadowSampleRate is the fraction, between 0 and 1, of LookupHost calls
+	// which are also resolved in the background through ShadowResolver and
+	// compared with the answer served, to validate the cache before fully
+	// trusting it. Comparisons are counted in Stats and reported to
+	// OnShadowResult. The answer compared is the one of the upstream
+	// resolver, before Canonicalize, AddrOrder, DNS64, MaxAddrsPerEntry and
+	// health checks change it, and lookups answered by SetStatic or Set are
+	// not shadowed. At most 16 shadow lookups run at once, further samples
+	// are dropped. If zero, shadowing is disabled.
+	ShadowSampleRate float64
+
+	// ShadowResolver is used for shadow lookups. If nil,
+	// net.DefaultResolver is used instead.
+	ShadowResolver DNSResolver
+
+	// OnShadowResult, if set, is called from a background goroutine with
+	// the outcome of every shadow lookup.
+	OnShadowResult func(ShadowResult)
+
+	// RetryAttempts is the number of times an upstream lookup failing with a
+	// temporary error, such as a timeout, is retried. Each attempt gets its
+	// own Timeout. If zero, lookups are not retried.
+	RetryAttempts int
+
+	// RetryBaseDelay is the delay before the first retry, doubled for every
+	// following one. If zero, 50ms is used.
+	RetryBaseDelay time.Duration
+
+	// RetryJitter is the fraction, between 0 and 1, by which each retry
+	// delay is randomly increased or decreased.
+	RetryJitter float64
+
+	// RefreshErrorPolicy decides what happens to a cache entry whose refresh
+	// failed with the given error. If nil, DefaultRefreshErrorPolicy is
+	// used.
+	RefreshErrorPolicy func(err error) RefreshAction
+
+	// ReverseNegativeTTL, if positive, is how long the absence of names for
+	// an address, a frequent answer to reverse lookups, is cached: the
+	// lookups of the address fail with the cached error until then. Like
+	// other entries, negative entries are refreshed while used.
+	ReverseNegativeTTL time.Duration
+
+	// EmptyResultPolicy decides what happens to successful upstream answers
+	// with no record, which dialers cannot use. The default, CacheEmpty,
+	// caches them like other answers.
+	EmptyResultPolicy EmptyResultPolicy
+
+	// EmptyNegativeTTL is how long empty answers are cached as errors with
+	// NegativeCacheEmpty. If zero, 5s is used.
+	EmptyNegativeTTL time.Duration
+
+	// OnChange, if set, is called when the addresses cached for a host are
+	// replaced by a different set, e.g. by a refresh, so that connection
+	// pools can drain connections to removed addresses. The order of the
+	// addresses is ignored. It is called synchronously by the goroutine
+	// which updated the entry and must not block.
+	OnChange func(host string, old, new []string)
+
+	// OnLookupStart and OnLookupDone, if set, are called when an upstream
+	// lookup starts and ends, for the lookups of callers as well as for
+	// background refreshes, which have no caller context to trace with
+	// httptrace. host is the address of reverse lookups. OnLookupDone gets
+	// the upstream answer, the lookup duration and whether other lookups
+	// of host joined the upstream one while it was running. They are
+	// called synchronously by the goroutine performing the lookup and must
+	// not block nor modify addrs.
+	OnLookupStart func(host string)
+	OnLookupDone  func(host string, addrs []string, err error, d time.Duration, coalesced bool)
+
+	// Invalidator, if set, receives an Invalidation when a host is removed
+	// with Remove or its addresses change, so that a fleet of resolvers can
+	// purge the host everywhere, see HandleInvalidation.
+	Invalidator Invalidator
+
+	// OnLookupError decides whether a lookup whose upstream query failed is
+	// answered with the cached records, if any, or with the error. The
+	// default is ServeStale.
+	OnLookupError LookupErrorPolicy
+
+	// ErrorLog receives the errors logged by ServeStaleAndLog. If nil, the
+	// standard logger of the log package is used.
+	ErrorLog *log.Logger
+
+	// MinTTL and MaxTTL, when positive, clamp the TTL reported by resolvers
+	// implementing TTLResolver, e.g. to give a minimum lifetime to records
+	// with a TTL of 0 or to cap records with a TTL of a day. Entries of
+	// resolvers not reporting TTLs have no expiry and are not affected.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// ExpiredPolicy decides what a lookup does with a cached entry past its
+	// TTL. By default, ResolveExpired, the entry is resolved again before
+	// answering.
+	ExpiredPolicy ExpiredPolicy
+
+	// ExpiredGCInterval, if positive, is the interval at which entries
+	// expired for longer than ExpiredGrace are deleted, independently of
+	// Refresh. If zero, expired entries are only deleted by Refresh once
+	// unused.
+	ExpiredGCInterval time.Duration
+
+	// ExpiredGrace is how long entries are kept past their expiry before
+	// the garbage collection deletes them, e.g. to keep serving them with
+	// ServeExpired or after lookup errors.
+	ExpiredGrace time.Duration
+
+	// RateLimit is the maximum sustained rate, in lookups per second, of
+	// queries sent upstream, cache misses, refreshes and retries combined.
+	// Lookups over the limit wait for their turn. If zero, the rate is not
+	// limited.
+	RateLimit float64
+
+	// RateBurst is the number of upstream lookups allowed at once above
+	// RateLimit. If zero, it is RateLimit rounded up.
+	RateBurst int
+
+	// RateLimitMaxWait bounds how long an upstream lookup waits for its
+	// turn under RateLimit: the lookups which would wait longer fail with
+	// ErrRateLimited, so that bursts do not queue up without limit. If
+	// zero, 5s is used.
+	RateLimitMaxWait time.Duration
+
+	// RateLimitFailFast makes upstream lookups over RateLimit fail with
+	// ErrRateLimited instead of waiting. Lookups of cached names then keep
+	// being served from the cache.
+	RateLimitFailFast bool
+
+	// MaxConcurrentLookups bounds the number of upstream lookups, cache
+	// misses and refreshes combined, running at once. Lookups over the
+	// limit wait for a running one to finish. If zero, concurrency is not
+	// limited.
+	MaxConcurrentLookups int
+
+	// LookupQueueTimeout bounds how long an upstream lookup waits for its
+	// turn under MaxConcurrentLookups before failing with
+	// ErrTooManyLookups. If zero, lookups wait as long as their context
+	// allows.
+	LookupQueueTimeout time.Duration
+
+	// LookupWaitTimeout bounds how long a lookup waits for the upstream
+	// lookup of its name, possibly started by another caller, independently
+	// of Timeout and of the caller context. A lookup giving up fails with
+	// ErrLookupWaitTimeout, or is answered with the cached records according
+	// to OnLookupError; the upstream lookup keeps running for the other
+	// callers. Refreshes and revalidations are not bounded. If zero, lookups
+	// wait as long as their context allows.
+	LookupWaitTimeout time.Duration
+
+	// BreakerThreshold, if positive, enables a circuit breaker per name:
+	// after that many consecutive failed upstream lookups of a name, its
+	// lookups stop going upstream for BreakerOpenDuration and fail with
+	// ErrCircuitOpen, or are answered with the cached records according to
+	// OnLookupError. Then a single probe lookup goes upstream, closing the
+	// breaker if it succeeds and opening it again otherwise.
+	BreakerThreshold int
+
+	// BreakerOpenDuration is how long a circuit breaker stays open. If
+	// zero, 30s is used.
+	BreakerOpenDuration time.Duration
+
+	// AuditSize, if positive, is the number of recent lookups recorded with
+	// their answer, source, duration and error, e.g. to find which
+	// addresses a host resolved to at the time of an incident. See
+	// RecentEvents.
+	AuditSize int
+
+	// Clock is the source of time for expiry, refreshes, retries and rate
+	// limiting. If nil, SystemClock is used.
+	Clock Clock
+
+	once   sync.Once
+	shards [shardCount]cacheShard
+	size   atomic.Int64
+	stats  resolverStats
+
+	// upstreamSet and timeoutSet hold the values of SetResolver and
+	// SetTimeout, overriding the fields.
+	upstreamSet atomic.Pointer[resolverBox]
+	timeoutSet  atomic.Pointer[time.Duration]
+
+	// system is the resolver used when none is set, configured by
+	// PreferGo, StrictErrors and Dial.
+	system *defaultResolverWithTrace
+
+	limiter   tokenBucket
+	lookupSem chan struct{}
+	shadowSem chan struct{}
+	audit     *auditLog
+
+	// ctx is cancelled by Close to stop the background work.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closed    atomic.Bool
+	loops     sync.WaitGroup
+
+	// group merges concurrent upstream lookups for the same key.
+	group singleflight.Group
+
+	// flights holds the upstream lookups in progress, by key, oldest
+	// first.
+	flightsMu sync.Mutex
+	flights   map[cacheKey][]*flight
+
+	// running counts the upstream lookups in progress, which Close waits
+	// for on flightsDone.
+	running     int
+	flightsDone *sync.Cond
+
+	// subnets is set once an entry or lookup is keyed by a client subnet,
+	// so that the paths handling a name without one only look for its
+	// subnet variants then, see keysOf.
+	subnets atomic.Bool
+
+	staticMu sync.Mutex
+	static   atomic.Pointer[staticHosts]
+
+	// watchers holds the channels returned by Watch, by host. watching
+	// counts them so that stores can skip notifying when there are none.
+	watchMu  sync.Mutex
+	watchers map[string]map[chan []string]struct{}
+	watching atomic.Int32
+
+	// scores holds the results reported through ReportResult, by address.
+	scoresMu sync.Mutex
+	scores   map[string]*addrScore
+
+	// upstreamAnswers counts the answers of each upstream, see
+	// Stats.UpstreamAnswers.
+	upstreamsMu     sync.Mutex
+	upstreamAnswers map[string]uint64
+
+	// pins holds the names of the pinned hosts, copied on write.
+	pinsMu sync.Mutex
+	pins   atomic.Pointer[map[string]bool]
+
+	// breakers holds the circuit breakers of the names whose last upstream
+	// lookups failed, see BreakerThreshold.
+	breakers breakers
+
+	// unreachable holds the addresses which failed the last health check.
+	unreachable atomic.Pointer[map[string]bool]
+}
+
+// Production defaults, see NewProduction.
+const (
+	productionTimeout         = 5 * time.Second
+	productionRefreshInterval = time.Minute
+	productionMaxEntries      = 10000
+)
+
+// NewProduction returns a Resolver with defaults suited to long running
+// services: upstream lookups time out after 5s, entries in use are refreshed
+// every minute in the background, at most 10000 entries are cached, and
+// cached addresses keep being served while refreshing them fails. Activity
+// is reported by Stats. Call Close to stop the background refresh.
+func NewProduction() *Resolver {
+	r := &Resolver{
+		Timeout:         productionTimeout,
+		RefreshInterval: productionRefreshInterval,
+		MaxEntries:      productionMaxEntries,
+	}
+	r.once.Do(r.init)
+	return r
+}
+
+// flight records when the upstream lookup for a key currently shared through
+// group started.
+type flight struct {
+	start time.Time
+
+	// joined is set once another lookup joined the flight. flightsMu must
+	// be held.
+	joined bool
+
+	// invalidated is set by Remove and Flush, so that the answer of the
+	// flight, which may predate them, is returned to its callers but not
+	// cached.
+	invalidated atomic.Bool
 }
 
-type cacheEntry struct {
-	rrs  []string
-	used bool
+// lookupResult is the value produced by a lookup function. hasTTL reports
+// whether the resolver reported ttl. source names the resolver which
+// answered, and upstream the one it got the answer from. err is set for
+// negative results, see ReverseNegativeTTL. noStore results are returned
+// but not cached, see DontCacheEmpty. flight is the upstream lookup which
+// produced the result, nil for the results not looked up.
+type lookupResult struct {
+	rrs      []string
+	ttl      time.Duration
+	hasTTL   bool
+	source   string
+	upstream string
+	err      error
+	noStore  bool
+	flight   *flight
+
+	// raw is the answer of the upstream resolver before Canonicalize,
+	// AddrOrder, DNS64 and MaxAddrsPerEntry, only kept for shadow lookups.
+	raw []string
 }
 
 // LookupAddr performs a reverse lookup for the given address, returning a list
 // of names mapping to that address.
 func (r *Resolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
 	r.once.Do(r.init)
-	return r.lookup(ctx, "r"+addr)
+	return r.lookup(ctx, cacheKey{rtype: TypePTR, name: addr}, 0, nil)
 }
 
 // LookupHost looks up the given host using the local resolver. It returns a
 // slice of that host's addresses.
 func (r *Resolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
 	r.once.Do(r.init)
-	return r.lookup(ctx, "h"+host)
+	if host, err = toASCII(host); err != nil {
+		return nil, err
+	}
+	if r.shadowSampled() {
+		key := cacheKey{rtype: TypeHost, name: host, subnet: contextSubnet(ctx)}
+		start := r.now()
+		addrs, err = r.lookup(ctx, key, 0, nil)
+		r.shadowLookupHost(key, err, r.now().Sub(start))
+	} else {
+		addrs, err = r.lookup(ctx, cacheKey{rtype: TypeHost, name: host}, 0, nil)
+	}
+	return r.shuffle(host, addrs), err
 }
 
 // refreshRecords refreshes cached entries which have been used at least once since
-// the last Refresh.
-func (r *Resolver) refreshRecords() {
+// the last Refresh, until ctx is done, and reports the outcome.
+func (r *Resolver) refreshRecords(ctx context.Context) (report RefreshReport) {
 	r.once.Do(r.init)
-	r.mu.RLock()
-	update := make([]string, 0, len(r.cache))
-	del := make([]string, 0, len(r.cache))
-	for key, entry := range r.cache {
-		if entry.used {
-			update = append(update, key)
-		} else {
-			del = append(del, key)
+	began := r.now()
+	report.Failed = make(map[string]error)
+	defer func() {
+		report.Duration = r.now().Sub(began)
+	}()
+	if r.closed.Load() {
+		return
+	}
+	// Close aborts the refresh, including its waits between entries.
+	ctx, cancel := r.cancelOnClose(ctx)
+	defer cancel()
+	r.touchWatched()
+	update := make([]refreshItem, 0, r.len())
+	var purged []cacheKey
+	policy := refreshPolicy{keep: r.UnusedPolicy, hotHits: r.RefreshHotHits, coldEvery: r.RefreshColdEvery}
+	if r.AdaptiveRefresh {
+		policy.maxCycles = r.AdaptiveRefreshMaxCycles
+		if policy.maxCycles <= 0 {
+			policy.maxCycles = defaultAdaptiveRefreshMaxCycles
 		}
 	}
-	r.mu.RUnlock()
+	for i := range r.shards {
+		n := len(purged)
+		update, purged = r.shards[i].purgeUnused(update, purged, policy, r.now())
+		r.size.Add(-int64(len(purged) - n))
+	}
+	for _, key := range purged {
+		report.Evicted = append(report.Evicted, key.reportName())
+	}
+	if r.RefreshHotHits > 0 {
+		// Refresh the hottest entries first, so that they are up to date
+		// even if the refresh is aborted.
+		sort.SliceStable(update, func(i, j int) bool {
+			return update[i].hits > update[j].hits
+		})
+	}
+	r.pruneScores()
+	r.pruneBreakers()
 
-	if len(del) > 0 {
-		r.mu.Lock()
-		for _, key := range del {
-			delete(r.cache, key)
-		}
-		r.mu.Unlock()
+	var slot time.Duration
+	if r.RefreshSpread > 0 && len(update) > 0 {
+		slot = r.RefreshSpread / time.Duration(len(update))
+	}
+	workers := r.RefreshConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(update) {
+		workers = len(update)
 	}
+	keys := make(chan cacheKey)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				evicted, err := r.refreshKey(ctx, key)
+				name := key.reportName()
+				mu.Lock()
+				if err != nil {
+					report.Failed[name] = err
+				} else {
+					report.Refreshed = append(report.Refreshed, name)
+				}
+				if evicted {
+					report.Evicted = append(report.Evicted, name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(keys)
 
-	for _, key := range update {
-		r.update(context.Background(), key, false)
+	start := r.now()
+	for i, item := range update {
+		if slot > 0 {
+			// Refresh each entry at a random point of its own slot of the
+			// window, so that refreshes neither burst nor line up across a
+			// fleet.
+			at := time.Duration(i)*slot + time.Duration(rand.Int63n(int64(slot)))
+			r.sleep(ctx, start.Add(at).Sub(r.now()))
+		}
+		select {
+		case keys <- item.key:
+		case <-ctx.Done():
+			return
+		}
 	}
+	return
 }
 
+// Refresh refreshes the cached entries used since the last Refresh and
+// deletes the others. Use RefreshWithReport to learn which failed.
 func (r *Resolver) Refresh() {
-	r.refreshRecords()
+	r.refreshRecords(context.Background())
+}
+
+// RefreshCtx is like Refresh but stops refreshing entries once ctx is done,
+// e.g. during shutdown. Entries not refreshed yet are kept.
+func (r *Resolver) RefreshCtx(ctx context.Context) {
+	r.refreshRecords(ctx)
 }
 
 func (r *Resolver) init() {
-	r.cache = make(map[string]*cacheEntry)
+	for i := range r.shards {
+		r.shards[i].entries = make(map[cacheKey]*cacheEntry)
+	}
+	r.flights = make(map[cacheKey][]*flight)
+	r.flightsDone = sync.NewCond(&r.flightsMu)
+	r.watchers = make(map[string]map[chan []string]struct{})
+	r.scores = make(map[string]*addrScore)
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.system = r.newSystemResolver()
+	if r.AuditSize > 0 {
+		r.audit = &auditLog{events: make([]Event, r.AuditSize)}
+	}
+	if r.MaxConcurrentLookups > 0 {
+		r.lookupSem = make(chan struct{}, r.MaxConcurrentLookups)
+	}
+	if r.ShadowSampleRate > 0 {
+		r.shadowSem = make(chan struct{}, shadowConcurrency)
+	}
+	if r.RefreshInterval > 0 {
+		r.loops.Add(1)
+		// Start the tickers right away, so that they count from init.
+		go r.refreshLoop(r.clock().NewTicker(r.RefreshInterval))
+	}
+	if r.ExpiredGCInterval > 0 {
+		r.loops.Add(1)
+		go r.expiredGCLoop(r.clock().NewTicker(r.ExpiredGCInterval))
+	}
+	if r.HealthCheckInterval > 0 {
+		r.loops.Add(1)
+		go r.healthLoop(r.clock().NewTicker(r.HealthCheckInterval))
+	}
 }
 
-// lookupGroup merges lookup calls together for lookups for the same host. The
-// lookupGroup key is is the LookupIPAddr.host argument.
-var lookupGroup singleflight.Group
+func (r *Resolver) refreshLoop(t Ticker) {
+	defer r.loops.Done()
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-t.C():
+			r.RefreshCtx(r.ctx)
+		}
+	}
+}
 
-func (r *Resolver) lookup(ctx context.Context, key string) (rrs []string, err error) {
+// lookup answers the lookup of key for the client subnet of ctx, from the
+// cache if possible, and records it with AuditSize. If res is not nil, it
+// is filled with how the lookup was answered. The records are copied if
+// CopyResults is set.
+func (r *Resolver) lookup(ctx context.Context, key cacheKey, opts LookupOption, res *Result) (rrs []string, err error) {
+	if r.closed.Load() {
+		return nil, ErrClosed
+	}
+	if key.subnet = contextSubnet(ctx); key.subnet != "" {
+		r.subnets.Store(true)
+	}
+	if r.audit == nil {
+		rrs, err = r.lookupCache(ctx, key, opts, res)
+		return r.results(rrs), err
+	}
+	if res == nil {
+		res = new(Result)
+	}
+	start := r.now()
+	rrs, err = r.lookupCache(ctx, key, opts, res)
+	r.recordLookup(key, start, rrs, err, res)
+	return r.results(rrs), err
+}
+
+// lookupCache is lookup without recording the lookup.
+func (r *Resolver) lookupCache(ctx context.Context, key cacheKey, opts LookupOption, res *Result) (rrs []string, err error) {
 	var found bool
-	rrs, found = r.load(key)
-	if !found {
-		rrs, err = r.update(ctx, key, true)
+	if isHostType(key.rtype) {
+		if rrs, found = r.loadStatic(key.name); found {
+			r.stats.hits.Add(1)
+			if res != nil {
+				res.Cached = true
+			}
+			if key.rtype != TypeHost {
+				if rrs = filterFamily(rrs, key.rtype); len(rrs) == 0 {
+					err = &net.DNSError{Err: "no such host", Name: key.name, IsNotFound: true}
+				}
+			}
+			return
+		}
+	}
+	switch {
+	case opts&NoCache != 0:
+		r.stats.misses.Add(1)
+		return r.lookupUncached(ctx, key)
+	case opts&ForceFresh != 0:
+		r.stats.misses.Add(1)
+		return r.update(ctx, key, true, nil)
+	}
+	var expired bool
+	rrs, expired, found = r.load(key)
+	if found && len(rrs) == 0 {
+		if err = r.negativeErr(key); err != nil {
+			if !expired || opts&CacheOnly != 0 {
+				r.stats.hits.Add(1)
+				r.stats.negativeHits.Add(1)
+				r.resultFromCache(res, key, expired)
+				return nil, err
+			}
+			// An expired negative entry is only served as such.
+			r.stats.expired.Add(1)
+			r.stats.misses.Add(1)
+			return r.update(ctx, key, true, nil)
+		}
+	}
+	if found && expired && opts&CacheOnly == 0 {
+		r.stats.expired.Add(1)
+		if r.ExpiredPolicy == ServeExpired {
+			r.stats.hits.Add(1)
+			r.resultFromCache(res, key, true)
+			r.revalidate(key)
+			return
+		}
+		r.stats.misses.Add(1)
+		return r.updateResult(ctx, key, res)
+	}
+	if found {
+		r.stats.hits.Add(1)
+		r.resultFromCache(res, key, expired)
+	} else if opts&CacheOnly != 0 {
+		r.stats.misses.Add(1)
+		err = ErrNotCached
+	} else {
+		r.stats.misses.Add(1)
+		rrs, err = r.updateResult(ctx, key, res)
 	}
 	return
 }
 
-func (r *Resolver) update(ctx context.Context, key string, used bool) (rrs []string, err error) {
-	c := lookupGroup.DoChan(key, r.lookupFunc(ctx, key))
+// updateResult is update for a lookup, serving the cached records if the
+// upstream lookup fails and reporting it in res.
+func (r *Resolver) updateResult(ctx context.Context, key cacheKey, res *Result) (rrs []string, err error) {
+	var stale bool
+	rrs, err = r.update(ctx, key, true, &stale)
+	if stale {
+		r.resultFromCache(res, key, true)
+	}
+	return
+}
+
+// update looks up key upstream and caches the answer, marking the entry used
+// or not. If stale is not nil and the upstream lookup fails, the records
+// already cached are returned instead of the error and *stale is set.
+func (r *Resolver) update(ctx context.Context, key cacheKey, used bool, stale *bool) (rrs []string, err error) {
+	if r.closed.Load() {
+		return nil, ErrClosed
+	}
+	if !r.breakerAllow(key) {
+		r.stats.breakerRejections.Add(1)
+		return r.serveStale(key, ErrCircuitOpen, stale)
+	}
+	groupKey := key.String()
+	own := new(flight)
+	c := r.group.DoChan(groupKey, r.trackFlight(key, own, r.lookupFunc(ctx, key)))
+	if r.OnLookupDone != nil {
+		r.markJoined(key, own)
+	}
+	wait, stop := r.waitTimer(ctx, used)
+	defer stop()
 	select {
+	case <-wait:
+		r.stats.waitTimeouts.Add(1)
+		return r.serveStale(key, ErrLookupWaitTimeout, stale)
+	case <-r.ctx.Done():
+		return nil, ErrClosed
 	case <-ctx.Done():
 		err = ctx.Err()
 		if err == context.DeadlineExceeded {
-			// If DNS request timed out for some reason, force future
-			// request to start the DNS lookup again rather than waiting
-			// for the current lookup to complete.
-			lookupGroup.Forget(key)
+			r.stats.timeouts.Add(1)
+			if r.shouldForget(key) {
+				// If DNS request timed out for some reason, force future
+				// request to start the DNS lookup again rather than waiting
+				// for the current lookup to complete.
+				r.group.Forget(groupKey)
+				r.stats.forgets.Add(1)
+			}
 		}
 	case res := <-c:
+		lr, _ := res.Val.(lookupResult)
+		if res.Err != nil {
+			if r.storeNegative(key, lr.flight, res.Err, used) {
+				return nil, res.Err
+			}
+			r.shard(key).setError(key, res.Err)
+			return r.serveStale(key, res.Err, stale)
+		}
+
 		if res.Shared {
 			// We had concurrent lookups, check if the cache is already updated
 			// by a friend.
-			var found bool
-			rrs, found = r.load(key)
-			if found {
+			var expired, found bool
+			rrs, expired, found = r.load(key)
+			if found && !expired {
 				return
 			}
 		}
 
-		if res.Err != nil {
-			var found bool
-			rrs, found = r.load(key)
-			if found {
-				return
-			}
-			return nil, res.Err
+		rrs = r.serveOrder(lr.rrs)
+
+		if !lr.noStore {
+			r.store(key, lr, used)
 		}
+	}
+	return
+}
 
-		rrs, _ = res.Val.([]string)
+// serveStale returns the records cached for key in place of err, setting
+// *stale, if stale is not nil and OnLookupError allows it, or err otherwise.
+func (r *Resolver) serveStale(key cacheKey, err error, stale *bool) (rrs []string, _ error) {
+	if stale == nil || r.OnLookupError == ReturnError {
+		return nil, err
+	}
+	rrs, _, found := r.load(key)
+	if !found {
+		return nil, err
+	}
+	if r.OnLookupError == ServeStaleAndLog {
+		r.logf("dnscache: serving cached records of %s after lookup error: %v", key.name, err)
+	}
+	*stale = true
+	return rrs, nil
+}
 
-		r.mu.Lock()
-		r.storeLocked(key, rrs, used)
-		r.mu.Unlock()
+// trackFlight wraps fn so that the start time of the upstream lookup is known
+// while it is shared through the singleflight group, and so that upstream
+// lookups are counted once no matter how many callers share them. f is the
+// flight registered for the lookup if fn runs.
+func (r *Resolver) trackFlight(key cacheKey, f *flight, fn func() (interface{}, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		f.start = r.now()
+		r.flightsMu.Lock()
+		r.flights[key] = append(r.flights[key], f)
+		r.running++
+		r.flightsMu.Unlock()
+		defer func() {
+			r.flightsMu.Lock()
+			r.removeFlightLocked(key, f)
+			r.endRunningLocked()
+			r.flightsMu.Unlock()
+		}()
+		r.stats.lookups.Add(1)
+		if r.OnLookupStart != nil {
+			r.OnLookupStart(key.name)
+		}
+		v, err := fn()
+		if err != nil {
+			r.stats.lookupErrors.Add(1)
+		}
+		if lr, ok := v.(lookupResult); ok {
+			lr.flight = f
+			v = lr
+		}
+		if r.OnLookupDone != nil {
+			lr, _ := v.(lookupResult)
+			r.flightsMu.Lock()
+			joined := f.joined
+			r.flightsMu.Unlock()
+			r.OnLookupDone(key.name, lr.rrs, err, r.now().Sub(f.start), joined)
+		}
+		return v, err
 	}
-	return
 }
 
-// lookupFunc returns lookup function for key. The type of the key is stored as
-// the first char and the lookup subject is the rest of the key.
-func (r *Resolver) lookupFunc(ctx context.Context, key string) func() (interface{}, error) {
-	if len(key) == 0 {
-		panic("lookupFunc with empty key")
+// latestFlightLocked returns the last upstream lookup of key started, nil
+// if there is none. flightsMu must be held.
+func (r *Resolver) latestFlightLocked(key cacheKey) *flight {
+	flights := r.flights[key]
+	if len(flights) == 0 {
+		return nil
 	}
+	return flights[len(flights)-1]
+}
 
-	var resolver DNSResolver = defaultResolver
-	if r.Resolver != nil {
-		resolver = r.Resolver
+// removeFlightLocked unregisters f, an upstream lookup of key which
+// returned. A forgotten flight may outlive its replacement. flightsMu must
+// be held.
+func (r *Resolver) removeFlightLocked(key cacheKey, f *flight) {
+	flights := r.flights[key]
+	for i := range flights {
+		if flights[i] == f {
+			flights = append(flights[:i], flights[i+1:]...)
+			break
+		}
+	}
+	if len(flights) == 0 {
+		delete(r.flights, key)
+	} else {
+		r.flights[key] = flights
 	}
+}
 
-	switch key[0] {
-	case 'h':
-		return func() (interface{}, error) {
-			ctx, cancel := r.prepareCtx(ctx)
-			defer cancel()
+// invalidateFlights forgets the upstream lookups of key in flight and
+// keeps them from caching their answer.
+func (r *Resolver) invalidateFlights(key cacheKey) {
+	r.flightsMu.Lock()
+	for _, f := range r.flights[key] {
+		f.invalidated.Store(true)
+	}
+	r.flightsMu.Unlock()
+	r.group.Forget(key.String())
+}
 
-			return resolver.LookupHost(ctx, key[1:])
+// markJoined marks the flight of key, if any, as joined by the lookup whose
+// own flight is own, once it went through the singleflight group: unless
+// own is the latest flight, the lookup joined it.
+func (r *Resolver) markJoined(key cacheKey, own *flight) {
+	r.flightsMu.Lock()
+	if f := r.latestFlightLocked(key); f != nil && f != own {
+		f.joined = true
+	}
+	r.flightsMu.Unlock()
+}
+
+// shouldForget reports whether the pending lookup for key must be forgotten
+// after a caller timed out waiting on it, according to ForgetAfter.
+func (r *Resolver) shouldForget(key cacheKey) bool {
+	switch {
+	case r.ForgetAfter == 0:
+		return true
+	case r.ForgetAfter < 0:
+		return false
+	}
+	r.flightsMu.Lock()
+	f := r.latestFlightLocked(key)
+	r.flightsMu.Unlock()
+	return f != nil && r.now().Sub(f.start) >= r.ForgetAfter
+}
+
+// lookupFunc returns lookup function for key.
+func (r *Resolver) lookupFunc(ctx context.Context, key cacheKey) func() (interface{}, error) {
+	resolver := r.upstream()
+	source := resolverName(resolver)
+	_, hasTTL := resolver.(TTLResolver)
+	var lookup func(ctx context.Context) (lr lookupResult, err error)
+	switch key.rtype {
+	case TypeHost:
+		lookup = func(ctx context.Context) (lr lookupResult, err error) {
+			lr.rrs, lr.ttl, err = lookupHostTTL(ctx, resolver, key.name)
+			if r.ShadowSampleRate > 0 {
+				lr.raw = append([]string(nil), lr.rrs...)
+			}
+			if r.Canonicalize {
+				lr.rrs = canonicalAddrs(lr.rrs)
+			}
+			if r.AddrOrder != OrderUpstream {
+				lr.rrs = orderAddrs(lr.rrs, r.AddrOrder)
+			}
+			if r.DNS64 {
+				lr.rrs = r.synthesizeDNS64(lr.rrs)
+			}
+			if r.MaxAddrsPerEntry > 0 {
+				lr.rrs = limitAddrs(lr.rrs, r.MaxAddrsPerEntry, r.AddrSubset)
+			}
+			lr.hasTTL = hasTTL && lr.ttl != NoTTL
+			lr.source = source
+			return
+		}
+	case TypeA, TypeAAAA:
+		lookup = func(ctx context.Context) (lr lookupResult, err error) {
+			lr.rrs, lr.ttl, err = lookupHostTTL(ctx, resolver, key.name)
+			if err != nil {
+				return lr, err
+			}
+			if r.Canonicalize {
+				lr.rrs = canonicalAddrs(lr.rrs)
+			}
+			if r.DNS64 && key.rtype == TypeAAAA {
+				lr.rrs = r.synthesizeDNS64(lr.rrs)
+			}
+			if lr.rrs = filterFamily(lr.rrs, key.rtype); len(lr.rrs) == 0 {
+				return lr, &net.DNSError{Err: "no such host", Name: key.name, IsNotFound: true}
+			}
+			if r.MaxAddrsPerEntry > 0 {
+				lr.rrs = limitAddrs(lr.rrs, r.MaxAddrsPerEntry, r.AddrSubset)
+			}
+			lr.hasTTL = hasTTL && lr.ttl != NoTTL
+			lr.source = source
+			return
+		}
+	case TypePTR:
+		lookup = func(ctx context.Context) (lr lookupResult, err error) {
+			lr.rrs, lr.ttl, err = lookupAddrTTL(ctx, resolver, key.name)
+			if r.Canonicalize {
+				lr.rrs = canonicalNames(lr.rrs)
+			}
+			lr.hasTTL = hasTTL && lr.ttl != NoTTL
+			lr.source = source
+			return
+		}
+	default:
+		if !isRecordType(key.rtype) {
+			panic("lookupFunc invalid key type: " + key.String())
+		}
+		lookup = func(ctx context.Context) (lr lookupResult, err error) {
+			lr.rrs, lr.ttl, err = lookupRecordsTTL(ctx, resolver, key.rtype, key.name)
+			if r.Canonicalize {
+				lr.rrs = canonicalRecords(key.rtype, lr.rrs)
+			}
+			lr.hasTTL = hasTTL && lr.ttl != NoTTL
+			lr.source = source
+			return
 		}
-	case 'r':
-		return func() (interface{}, error) {
-			ctx, cancel := r.prepareCtx(ctx)
+	}
+
+	return func() (interface{}, error) {
+		ctx, cancel := r.lookupCtx(ctx)
+		defer cancel()
+		lr, err := r.retry(ctx, func() (lookupResult, error) {
+			if err := r.waitRateLimit(ctx); err != nil {
+				return lookupResult{}, err
+			}
+			release, err := r.acquireLookup(ctx)
+			if err != nil {
+				return lookupResult{}, err
+			}
+			defer release()
+			ctx, cancel := r.attemptCtx(ctx)
 			defer cancel()
+			if key.subnet != "" {
+				ctx = withSubnet(ctx, key.subnet)
+			}
+			ctx, rec := withUpstreamRecorder(ctx)
 
-			return resolver.LookupAddr(ctx, key[1:])
+			lr, err := lookup(ctx)
+			if err == nil || isNotFound(err) {
+				lr.upstream = rec.answeredBy(resolver)
+				r.countUpstream(lr.upstream)
+			}
+			return lr, err
+		})
+		r.breakerRecord(key, err)
+		if err == nil && len(lr.rrs) == 0 {
+			lr, err = r.emptyResult(key, lr)
 		}
-	default:
-		panic("lookupFunc invalid key type: " + key)
+		return lr, err
 	}
 }
 
-func (r *Resolver) prepareCtx(origContext context.Context) (ctx context.Context, cancel context.CancelFunc) {
-	ctx = context.Background()
-	if r.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
-	} else {
-		cancel = func() {}
+// resolverName returns the name under which entries answered by resolver
+// are reported: its String method if it has one, its type otherwise.
+func resolverName(resolver DNSResolver) string {
+	if _, ok := resolver.(*defaultResolverWithTrace); ok {
+		return "system"
+	}
+	if s, ok := resolver.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", resolver)
+}
+
+// sleep pauses for d or until ctx is done, whichever happens first.
+func (r *Resolver) sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := r.clock().NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C():
+	}
+}
+
+// lookupHostTTL looks up host through resolver, along with the TTL of the
+// answer if resolver implements TTLResolver, or NoTTL otherwise.
+func lookupHostTTL(ctx context.Context, resolver DNSResolver, host string) (addrs []string, ttl time.Duration, err error) {
+	if tr, ok := resolver.(TTLResolver); ok {
+		return tr.LookupHostTTL(ctx, host)
+	}
+	addrs, err = resolver.LookupHost(ctx, host)
+	return addrs, NoTTL, err
+}
+
+// lookupAddrTTL is like lookupHostTTL for reverse lookups.
+func lookupAddrTTL(ctx context.Context, resolver DNSResolver, addr string) (names []string, ttl time.Duration, err error) {
+	if tr, ok := resolver.(TTLResolver); ok {
+		return tr.LookupAddrTTL(ctx, addr)
+	}
+	names, err = resolver.LookupAddr(ctx, addr)
+	return names, NoTTL, err
+}
+
+// lookupCtx returns the context of an upstream lookup made for a caller
+// with context origContext, which also bounds its rate limit wait and
+// retries: origContext itself with PropagateContext, and otherwise a
+// context detached from it but keeping its httptrace DNS hooks. Both are
+// cancelled by Close.
+func (r *Resolver) lookupCtx(origContext context.Context) (ctx context.Context, cancel context.CancelFunc) {
+	if r.PropagateContext {
+		return r.cancelOnClose(origContext)
 	}
 
+	ctx, cancel = r.ctx, func() {}
 	// If a httptrace has been attached to the given context it will be copied over to the newly created context. We only need to copy pointers
 	// to DNSStart and DNSDone hooks
 	if trace := httptrace.ContextClientTrace(origContext); trace != nil {
@@ -187,68 +1161,145 @@ func (r *Resolver) prepareCtx(origContext context.Context) (ctx context.Context,
 
 		ctx = httptrace.WithClientTrace(ctx, derivedTrace)
 	}
-
 	return
 }
 
-func (r *Resolver) load(key string) (rrs []string, found bool) {
-	r.mu.RLock()
-	var entry *cacheEntry
-	entry, found = r.cache[key]
-	if !found {
-		r.mu.RUnlock()
-		return
+// attemptCtx bounds ctx, returned by lookupCtx, by Timeout for a single
+// attempt of an upstream lookup.
+func (r *Resolver) attemptCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := r.timeout(); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
 	}
-	rrs = entry.rrs
-	used := entry.used
-	r.mu.RUnlock()
+	return ctx, func() {}
+}
 
-	if !used {
-		r.mu.Lock()
-		entry.used = true
-		r.cache[key] = entry
-		r.mu.Unlock()
-	}
-	return rrs, true
+var defaultResolver = &defaultResolverWithTrace{}
+
+// defaultResolverWithTrace calls `LookupIP` instead of `LookupHost` on `net.DefaultResolver` and calls the `DNSStart` and `DNSDone` hooks
+// itself, which `LookupHost` does not. By implementing `DNSResolver`, backward compatibility can be ensured.
+type defaultResolverWithTrace struct {
+	// resolver performs the lookups, net.DefaultResolver if nil.
+	resolver *net.Resolver
 }
 
-func (r *Resolver) storeLocked(key string, rrs []string, used bool) {
-	if entry, found := r.cache[key]; found {
-		// Update existing entry in place
-		entry.rrs = rrs
-		entry.used = used
-		return
-	}
-	r.cache[key] = &cacheEntry{
-		rrs:  rrs,
-		used: used,
+func (d *defaultResolverWithTrace) netResolver() *net.Resolver {
+	if d.resolver != nil {
+		return d.resolver
 	}
+	return net.DefaultResolver
 }
 
-var defaultResolver = &defaultResolverWithTrace{}
-
-// defaultResolverWithTrace calls `LookupIP` instead of `LookupHost` on `net.DefaultResolver` in order to cause invocation of the `DNSStart`
-// and `DNSDone` hooks. By implementing `DNSResolver`, backward compatibility can be ensured.
-type defaultResolverWithTrace struct{}
+// familyGrace is how long the system resolver waits for the answer of an
+// address family once the other one answered, like the resolution delay of
+// Happy Eyeballs (RFC 8305).
+const familyGrace = 50 * time.Millisecond
 
 func (d *defaultResolverWithTrace) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
-	// `net.Resolver#LookupHost` does not cause invocation of `net.Resolver#lookupIPAddr`, therefore the `DNSStart` and `DNSDone` tracing hooks
-	// built into the stdlib are never called. `LookupIP`, despite it's name, can also be used to lookup a hostname but does cause these hooks to be
-	// triggered. The format of the reponse is different, therefore it needs this thin wrapper converting it.
-	rawIPs, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	// `net.Resolver#LookupHost` does not cause invocation of the `DNSStart` and `DNSDone` tracing hooks, so they are called here, once
+	// for the lookup of both address families.
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	ips, err := d.lookupFamilies(untracedContext{ctx}, host)
+	if trace != nil && trace.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, ip := range ips {
+			info.Addrs = append(info.Addrs, net.IPAddr{IP: ip})
+		}
+		trace.DNSDone(info)
+	}
 	if err != nil {
 		return nil, err
 	}
+	addrs = make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	orderRFC6724(addrs, routable)
+	return addrs, nil
+}
 
-	cookedIPs := make([]string, len(rawIPs))
+// lookupFamilies looks up the IPv4 and IPv6 addresses of host separately,
+// so that a failure of one family, common on broken IPv6 paths, neither
+// fails nor delays the other one beyond familyGrace.
+func (d *defaultResolverWithTrace) lookupFamilies(ctx context.Context, host string) ([]net.IP, error) {
+	type answer struct {
+		family int
+		ips    []net.IP
+		err    error
+	}
+	networks := [2]string{"ip4", "ip6"}
+	// Buffered so that a family answering after familyGrace does not
+	// block.
+	answers := make(chan answer, len(networks))
+	for i := range networks {
+		go func(i int) {
+			ips, err := d.netResolver().LookupIP(ctx, networks[i], host)
+			answers <- answer{i, ips, err}
+		}(i)
+	}
 
-	for i, v := range rawIPs {
-		cookedIPs[i] = v.String()
+	var (
+		ips   [2][]net.IP
+		errs  [2]error
+		grace <-chan time.Time
+	)
+wait:
+	for range networks {
+		select {
+		case a := <-answers:
+			ips[a.family], errs[a.family] = a.ips, a.err
+			if len(a.ips) > 0 && grace == nil {
+				t := time.NewTimer(familyGrace)
+				defer t.Stop()
+				grace = t.C
+			}
+		case <-grace:
+			break wait
+		}
+	}
+	if all := append(ips[0], ips[1]...); len(all) > 0 {
+		return all, nil
+	}
+	// Prefer reporting a failure over the absence of one family.
+	for _, err := range errs {
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+	}
+	if errs[0] != nil {
+		return nil, errs[0]
 	}
+	return nil, errs[1]
+}
 
-	return cookedIPs, nil
+// untracedContext hides the httptrace.ClientTrace of its parent from the
+// lookups of each family, as the DNS hooks are called for both at once.
+// httptrace also hands the hooks to the net package under a key of its
+// internal nettrace package, hidden too.
+type untracedContext struct {
+	context.Context
+}
+
+func (c untracedContext) Value(key interface{}) interface{} {
+	if t := reflect.TypeOf(key); t != nil && t.PkgPath() == "internal/nettrace" {
+		return nil
+	}
+	v := c.Context.Value(key)
+	if _, ok := v.(*httptrace.ClientTrace); ok {
+		return nil
+	}
+	return v
 }
 
 func (d *defaultResolverWithTrace) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
-	return net.DefaultResolver.LookupAddr(ctx, addr)
+	return d.netResolver().LookupAddr(ctx, addr)
+}
+
+// isNotFound reports whether err is an authoritative answer that the name
+// does not exist, as opposed to a failure to get an answer.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
 }
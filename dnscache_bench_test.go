@@ -0,0 +1,93 @@
+package dnscache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func benchmarkHosts(n int) []string {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = "host" + strconv.Itoa(i) + ".example.com"
+	}
+	return hosts
+}
+
+func BenchmarkResolver_LookupHostParallel(b *testing.B) {
+	r := &Resolver{Resolver: BadResolver{}}
+	hosts := benchmarkHosts(1024)
+	for _, host := range hosts {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		i := 0
+		for pb.Next() {
+			_, _ = r.LookupHost(ctx, hosts[i%len(hosts)])
+			i++
+		}
+	})
+}
+
+func BenchmarkResolver_LookupHost(b *testing.B) {
+	r := &Resolver{Resolver: BadResolver{}}
+	ctx := context.Background()
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.LookupHost(ctx, "example.com")
+	}
+}
+
+func BenchmarkResolver_LookupHostCopy(b *testing.B) {
+	r := &Resolver{Resolver: BadResolver{}, CopyResults: true}
+	ctx := context.Background()
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.LookupHost(ctx, "example.com")
+	}
+}
+
+func BenchmarkResolver_LookupAddr(b *testing.B) {
+	r := &Resolver{Resolver: &slowResolver{}}
+	ctx := context.Background()
+	if _, err := r.LookupAddr(ctx, "192.0.2.1"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.LookupAddr(ctx, "192.0.2.1")
+	}
+}
+
+func BenchmarkResolver_Refresh(b *testing.B) {
+	r := &Resolver{Resolver: BadResolver{}}
+	ctx := context.Background()
+	hosts := benchmarkHosts(1024)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, host := range hosts {
+			_, _ = r.LookupHost(ctx, host)
+		}
+		r.Refresh()
+	}
+}
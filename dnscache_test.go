@@ -3,37 +3,41 @@ package dnscache
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http/httptrace"
+	"runtime"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 func TestClearCache(t *testing.T) {
 	r := &Resolver{}
 	_, _ = r.LookupHost(context.Background(), "google.com")
-	if e := r.cache["hgoogle.com"]; e != nil && !e.used {
+	if e := r.entry("hgoogle.com"); e != nil && !e.used.Load() {
 		t.Error("cache entry used flag is false, want true")
 	}
 	r.Refresh()
-	if e := r.cache["hgoogle.com"]; e != nil && e.used {
+	if e := r.entry("hgoogle.com"); e != nil && e.used.Load() {
 		t.Error("cache entry used flag is true, want false")
 	}
 	r.Refresh()
-	if e := r.cache["hgoogle.com"]; e != nil {
+	if e := r.entry("hgoogle.com"); e != nil {
 		t.Error("cache entry is not cleared")
 	}
 
 	_, _ = r.LookupHost(context.Background(), "google.com")
-	if e := r.cache["hgoogle.com"]; e != nil && !e.used {
+	if e := r.entry("hgoogle.com"); e != nil && !e.used.Load() {
 		t.Error("cache entry used flag is false, want true")
 	}
 	r.Refresh()
-	if e := r.cache["hgoogle.com"]; e != nil && e.used {
+	if e := r.entry("hgoogle.com"); e != nil && e.used.Load() {
 		t.Error("cache entry used flag is true, want false")
 	}
 	r.Refresh()
-	if e := r.cache["hgoogle.com"]; e != nil {
+	if e := r.entry("hgoogle.com"); e != nil {
 		t.Error("cache entry is not cleared")
 	}
 
@@ -43,7 +47,7 @@ func TestClearCache(t *testing.T) {
 	_, _ = br.LookupHost(context.Background(), "google.com")
 	br.Resolver = BadResolver{choke: true}
 	br.Refresh()
-	if len(br.cache["hgoogle.com"].rrs) == 0 {
+	if len(br.entry("hgoogle.com").rrs) == 0 {
 		t.Error("cache entry is cleared")
 	}
 }
@@ -116,6 +120,86 @@ func TestResolver_LookupHost_DNSHooksGetTriggerd(t *testing.T) {
 	}
 }
 
+// startDroppingDNSServer is like startTestDNSServer but never answers the
+// queries of type drop.
+func startDroppingDNSServer(t *testing.T, handler testDNSHandler, drop dnsmessage.Type) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) == 0 || msg.Questions[0].Type == drop {
+				continue
+			}
+			resp, err := answerQuery(buf[:n], handler)
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// testSystemResolver returns the system resolver querying server.
+func testSystemResolver(server string) *defaultResolverWithTrace {
+	return &defaultResolverWithTrace{resolver: &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", server)
+		},
+	}}
+}
+
+func TestSystemResolver_Hooks(t *testing.T) {
+	var starts, dones atomic.Int32
+	var addrs []net.IPAddr
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { starts.Add(1) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			dones.Add(1)
+			addrs = info.Addrs
+		},
+	})
+	d := testSystemResolver(startTestDNSServer(t, testRawHandler))
+	if _, err := d.LookupHost(ctx, "example.test"); err != nil {
+		t.Fatal(err)
+	}
+	if starts.Load() != 1 || dones.Load() != 1 {
+		t.Errorf("DNSStart called %d times and DNSDone %d times, want once each", starts.Load(), dones.Load())
+	}
+	if len(addrs) != 3 {
+		t.Errorf("DNSDone reported %v, want the 3 addresses", addrs)
+	}
+}
+
+func TestSystemResolver_HungFamily(t *testing.T) {
+	d := testSystemResolver(startDroppingDNSServer(t, testRawHandler, dnsmessage.TypeAAAA))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	addrs, err := d.LookupHost(ctx, "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameAddrs(addrs, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("addrs = %v, want the IPv4 addresses", addrs)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookup took %v waiting for the unanswered family", elapsed)
+	}
+}
+
 type fakeResolver struct {
 	LookupHostCalls int32
 	LookupAddrCalls int32
@@ -129,3 +213,279 @@ func (f *fakeResolver) LookupAddr(ctx context.Context, addr string) (names []str
 	atomic.AddInt32(&f.LookupAddrCalls, 1)
 	return nil, errors.New("not implemented")
 }
+
+func lookupWithTimeout(r *Resolver, host string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := r.LookupHost(ctx, host)
+	return err
+}
+
+func TestResolver_ForgetAfter(t *testing.T) {
+	tests := []struct {
+		name        string
+		forgetAfter time.Duration
+		wantForgets uint64
+		wantLookups uint64
+	}{
+		{"default", 0, 2, 2},
+		{"never", -1, 0, 1},
+		{"cap", 30 * time.Millisecond, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Resolver{
+				Resolver:    &slowResolver{delay: 200 * time.Millisecond},
+				ForgetAfter: tt.forgetAfter,
+			}
+			if err := lookupWithTimeout(r, "example.com", 10*time.Millisecond); err != context.DeadlineExceeded {
+				t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+			}
+			time.Sleep(40 * time.Millisecond)
+			if err := lookupWithTimeout(r, "example.com", 10*time.Millisecond); err != context.DeadlineExceeded {
+				t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+			}
+
+			stats := r.Stats()
+			if stats.Timeouts != 2 {
+				t.Errorf("Timeouts = %d, want 2", stats.Timeouts)
+			}
+			if stats.Forgets != tt.wantForgets {
+				t.Errorf("Forgets = %d, want %d", stats.Forgets, tt.wantForgets)
+			}
+			if stats.Lookups != tt.wantLookups {
+				t.Errorf("Lookups = %d, want %d", stats.Lookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestResolver_MaxEntries(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, MaxEntries: 10}
+	for _, host := range benchmarkHosts(25) {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := r.len(); n != 10 {
+		t.Errorf("cache holds %d entries, want 10", n)
+	}
+	if n := r.Stats().Evictions; n != 15 {
+		t.Errorf("Evictions = %d, want 15", n)
+	}
+}
+
+func TestCacheShard_EvictOne(t *testing.T) {
+	s := &cacheShard{entries: make(map[cacheKey]*cacheEntry)}
+	add := func(name string, hits uint64, used bool) cacheKey {
+		key := cacheKey{rtype: TypeHost, name: name}
+		entry := &cacheEntry{}
+		entry.hits.Store(hits)
+		entry.used.Store(used)
+		s.entries[key] = entry
+		return key
+	}
+	add("hot.example.com", 9, true)
+	cold := add("cold.example.com", 1, true)
+	add("warm.example.com", 5, true)
+	keep := add("new.example.com", 0, true)
+
+	// Among used entries, the one with the fewest hits goes first.
+	if !s.evictOne(keep) {
+		t.Fatal("evictOne() deleted nothing")
+	}
+	if _, found := s.entries[cold]; found || len(s.entries) != 3 {
+		t.Errorf("evictOne() left %v, want %s deleted", s.entries, cold.name)
+	}
+
+	// An unused entry goes before any used one.
+	unused := add("unused.example.com", 0, false)
+	add("idle.example.com", 0, true)
+	s.evictOne(keep)
+	if _, found := s.entries[unused]; found {
+		t.Errorf("evictOne() kept the unused entry")
+	}
+}
+
+func TestResolver_RefreshInterval(t *testing.T) {
+	f := &slowResolver{}
+	r := &Resolver{Resolver: f, RefreshInterval: 10 * time.Millisecond}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(35 * time.Millisecond)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry is refreshed once, then purged as it was not used again.
+	calls := atomic.LoadInt32(&f.calls)
+	if calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+	if r.entry("hexample.com") != nil {
+		t.Error("unused entry was not purged")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&f.calls); n != calls {
+		t.Errorf("upstream called %d times after Close, want %d", n, calls)
+	}
+}
+
+func TestNewProduction(t *testing.T) {
+	r := NewProduction()
+	defer r.Close()
+	if r.Timeout <= 0 || r.RefreshInterval <= 0 || r.MaxEntries <= 0 {
+		t.Errorf("NewProduction() = {Timeout: %v, RefreshInterval: %v, MaxEntries: %d}, want all set",
+			r.Timeout, r.RefreshInterval, r.MaxEntries)
+	}
+}
+
+// doneRecorder blocks every lookup until its context is done and reports
+// how long that took on its channel.
+type doneRecorder struct {
+	blockingResolver
+	done chan time.Duration
+}
+
+func (r doneRecorder) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	start := time.Now()
+	<-ctx.Done()
+	r.done <- time.Since(start)
+	return nil, ctx.Err()
+}
+
+func TestResolver_PropagateContext(t *testing.T) {
+	for _, propagate := range []bool{false, true} {
+		upstream := doneRecorder{done: make(chan time.Duration, 1)}
+		r := &Resolver{Resolver: upstream, PropagateContext: propagate, Timeout: 200 * time.Millisecond}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, _ = r.LookupHost(ctx, "example.com")
+		cancel()
+
+		// A detached upstream lookup keeps running until Timeout.
+		d := <-upstream.done
+		if cancelled := d < 100*time.Millisecond; cancelled != propagate {
+			t.Errorf("PropagateContext = %v: upstream lookup ran for %v", propagate, d)
+		}
+	}
+}
+
+// gateResolver answers LookupHost like BadResolver once release is closed.
+// If started is not nil, it receives the host of every lookup reaching the
+// resolver.
+type gateResolver struct {
+	BadResolver
+	started chan string
+	release chan struct{}
+}
+
+func (r gateResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	if r.started != nil {
+		r.started <- host
+	}
+	<-r.release
+	return r.BadResolver.LookupHost(ctx, host)
+}
+
+// lookupBehindGate starts a lookup of host on r, whose upstream is gate, and
+// returns once it reached gate, along with the channel receiving its
+// error.
+func lookupBehindGate(r *Resolver, gate gateResolver, host string) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.LookupHost(context.Background(), host)
+		errs <- err
+	}()
+	<-gate.started
+	return errs
+}
+
+func TestResolver_LookupHooks(t *testing.T) {
+	type done struct {
+		host      string
+		addrs     []string
+		coalesced bool
+	}
+	var (
+		started = make(chan string, 10)
+		dones   = make(chan done, 10)
+	)
+	upstream := gateResolver{release: make(chan struct{})}
+	r := &Resolver{
+		Resolver:      upstream,
+		OnLookupStart: func(host string) { started <- host },
+		OnLookupDone: func(host string, addrs []string, err error, d time.Duration, coalesced bool) {
+			if err != nil {
+				t.Errorf("OnLookupDone(%s): err = %v", host, err)
+			}
+			dones <- done{host, addrs, coalesced}
+		},
+	}
+
+	errs := make(chan error, 2)
+	lookup := func() {
+		_, err := r.LookupHost(context.Background(), "example.com")
+		errs <- err
+	}
+	go lookup()
+	if host := <-started; host != "example.com" {
+		t.Errorf("OnLookupStart(%s), want example.com", host)
+	}
+	go lookup()
+	// Let the second lookup join the first one before releasing it.
+	key := cacheKey{rtype: TypeHost, name: "example.com"}
+	for {
+		r.flightsMu.Lock()
+		f := r.latestFlightLocked(key)
+		joined := f != nil && f.joined
+		r.flightsMu.Unlock()
+		if joined {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(upstream.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := <-dones; d.host != "example.com" || len(d.addrs) == 0 || !d.coalesced {
+		t.Errorf("OnLookupDone(%s, %v, coalesced %v), want coalesced addresses of example.com", d.host, d.addrs, d.coalesced)
+	}
+
+	// Background refreshes are reported too.
+	r.Refresh()
+	if host := <-started; host != "example.com" {
+		t.Errorf("refresh: OnLookupStart(%s), want example.com", host)
+	}
+	if d := <-dones; d.coalesced {
+		t.Error("refresh: OnLookupDone reports a coalesced lookup")
+	}
+	select {
+	case host := <-started:
+		t.Errorf("unexpected OnLookupStart(%s)", host)
+	default:
+	}
+}
+
+func TestResolver_CopyResults(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, CopyResults: true}
+	ctx := context.Background()
+	addrs, err := r.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs[0] = "192.0.2.1"
+	peeked, _ := r.Peek("example.com")
+	peeked[0] = "192.0.2.2"
+
+	addrs, err = r.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "216.58.192.238" {
+		t.Errorf("cached addresses are %v after callers modified them", addrs)
+	}
+}
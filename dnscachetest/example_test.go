@@ -0,0 +1,26 @@
+package dnscachetest_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/dnscache"
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func Example() {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1")
+	r := &dnscache.Resolver{Resolver: upstream}
+
+	for i := 0; i < 3; i++ {
+		addrs, _ := r.LookupHost(context.Background(), "example.com")
+		fmt.Println(addrs)
+	}
+	fmt.Println(upstream.Calls("example.com"))
+	// Output:
+	// [192.0.2.1]
+	// [192.0.2.1]
+	// [192.0.2.1]
+	// 1
+}
@@ -0,0 +1,148 @@
+// Package dnscachetest provides a scriptable fake upstream for testing code
+// built on dnscache.
+//
+//	upstream := dnscachetest.NewResolver()
+//	upstream.SetHost("example.com", "192.0.2.1")
+//	r := &dnscache.Resolver{Resolver: upstream}
+package dnscachetest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver is a fake dnscache.DNSResolver answering from scripted responses.
+// Names without a response fail with a not found error. It is safe for
+// concurrent use, so responses can be changed while lookups run.
+type Resolver struct {
+	mu        sync.Mutex
+	hosts     map[string]response
+	addrs     map[string]response
+	latencies map[string]time.Duration
+	calls     map[string]int
+	total     int
+}
+
+type response struct {
+	rrs []string
+	err error
+}
+
+// NewResolver returns a Resolver without any response.
+func NewResolver() *Resolver {
+	return &Resolver{
+		hosts:     make(map[string]response),
+		addrs:     make(map[string]response),
+		latencies: make(map[string]time.Duration),
+		calls:     make(map[string]int),
+	}
+}
+
+// SetHost makes lookups of host return addrs.
+func (r *Resolver) SetHost(host string, addrs ...string) {
+	r.set(r.hosts, host, response{rrs: addrs})
+}
+
+// SetHostError makes lookups of host fail with err.
+func (r *Resolver) SetHostError(host string, err error) {
+	r.set(r.hosts, host, response{err: err})
+}
+
+// SetAddr makes reverse lookups of addr return names.
+func (r *Resolver) SetAddr(addr string, names ...string) {
+	r.set(r.addrs, addr, response{rrs: names})
+}
+
+// SetAddrError makes reverse lookups of addr fail with err.
+func (r *Resolver) SetAddrError(addr string, err error) {
+	r.set(r.addrs, addr, response{err: err})
+}
+
+func (r *Resolver) set(m map[string]response, name string, resp response) {
+	r.mu.Lock()
+	m[name] = resp
+	r.mu.Unlock()
+}
+
+// SetLatency delays the lookups of name, a host or an address, by d. An
+// empty name sets the latency of the names without their own. A lookup
+// whose context is done before d elapsed fails with the context error.
+func (r *Resolver) SetLatency(name string, d time.Duration) {
+	r.mu.Lock()
+	r.latencies[name] = d
+	r.mu.Unlock()
+}
+
+// Calls returns the number of lookups of name, a host or an address.
+func (r *Resolver) Calls(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[name]
+}
+
+// TotalCalls returns the number of lookups of all names.
+func (r *Resolver) TotalCalls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
+
+// Reset forgets the lookup counts.
+func (r *Resolver) Reset() {
+	r.mu.Lock()
+	r.calls = make(map[string]int)
+	r.total = 0
+	r.mu.Unlock()
+}
+
+// LookupHost implements dnscache.DNSResolver.
+func (r *Resolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	return r.lookup(ctx, r.hosts, host)
+}
+
+// LookupAddr implements dnscache.DNSResolver.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	return r.lookup(ctx, r.addrs, addr)
+}
+
+func (r *Resolver) lookup(ctx context.Context, m map[string]response, name string) ([]string, error) {
+	r.mu.Lock()
+	r.calls[name]++
+	r.total++
+	resp, found := m[name]
+	latency, ok := r.latencies[name]
+	if !ok {
+		latency = r.latencies[""]
+	}
+	r.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+	if !found {
+		return nil, NotFound(name)
+	}
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return append([]string(nil), resp.rrs...), nil
+}
+
+// NotFound returns the error of a lookup of name which does not exist.
+func NotFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// Temporary returns the error of a lookup of name which failed
+// temporarily, e.g. because the nameserver timed out.
+func Temporary(name string) error {
+	return &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+}
@@ -0,0 +1,67 @@
+package dnscachetest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	r := NewResolver()
+	r.SetHost("example.com", "192.0.2.1", "192.0.2.2")
+	r.SetAddr("192.0.2.1", "example.com.")
+	r.SetHostError("down.example.com", Temporary("down.example.com"))
+
+	addrs, err := r.LookupHost(ctx, "example.com")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("LookupHost = %v, %v", addrs, err)
+	}
+	names, err := r.LookupAddr(ctx, "192.0.2.1")
+	if err != nil || !reflect.DeepEqual(names, []string{"example.com."}) {
+		t.Errorf("LookupAddr = %v, %v", names, err)
+	}
+
+	var dnsErr *net.DNSError
+	if _, err := r.LookupHost(ctx, "down.example.com"); !errors.As(err, &dnsErr) || !dnsErr.IsTemporary {
+		t.Errorf("scripted error = %v, want temporary DNS error", err)
+	}
+	if _, err := r.LookupHost(ctx, "missing.example.com"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("unscripted host error = %v, want not found", err)
+	}
+
+	if n := r.Calls("example.com"); n != 1 {
+		t.Errorf("Calls(example.com) = %d, want 1", n)
+	}
+	if n := r.TotalCalls(); n != 4 {
+		t.Errorf("TotalCalls() = %d, want 4", n)
+	}
+	r.Reset()
+	if n := r.TotalCalls(); n != 0 {
+		t.Errorf("TotalCalls() = %d after Reset, want 0", n)
+	}
+}
+
+func TestResolver_SetLatency(t *testing.T) {
+	r := NewResolver()
+	r.SetHost("example.com", "192.0.2.1")
+	r.SetLatency("", time.Hour)
+	r.SetLatency("example.com", 10*time.Millisecond)
+
+	start := time.Now()
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("lookup took %v, want at least 10ms", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.LookupHost(ctx, "other.example.com"); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,123 @@
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Well known DNS over HTTPS endpoints, for use as DoHResolver.URL.
+const (
+	CloudflareDoHURL = "https://cloudflare-dns.com/dns-query"
+	GoogleDoHURL     = "https://dns.google/dns-query"
+)
+
+// dohMediaType is the media type of DNS messages exchanged over HTTPS.
+const dohMediaType = "application/dns-message"
+
+// maxDNSMessageSize is the largest DNS message a stream transport can carry.
+const maxDNSMessageSize = 65535
+
+// DoHResolver is a DNSResolver performing DNS over HTTPS lookups as
+// specified by RFC 8484. It supports A, AAAA, PTR, CNAME, MX, SRV and TXT
+// lookups and implements TTLResolver.
+type DoHResolver struct {
+	// URL is the DoH endpoint, e.g. CloudflareDoHURL or an internal
+	// gateway.
+	URL string
+
+	// Client is used to send the queries. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	// RequireDNSSEC makes lookups request DNSSEC validation from the server
+	// and fail with ErrUnauthenticatedAnswer when it does not vouch for
+	// the answer with the AD bit. The records are not validated locally,
+	// so the server must be a validating resolver reached over a path
+	// trusted not to tamper with the bit, as HTTPS is.
+	RequireDNSSEC bool
+
+	// ClientSubnet, if set, is sent with every query as EDNS Client Subnet
+	// (RFC 7871), so that servers of CDNs answer with addresses close to
+	// it. It should be truncated, e.g. to a /24 or /56, to not disclose
+	// the address of the host. ContextWithClientSubnet overrides it.
+	ClientSubnet *net.IPNet
+
+	// AutoClientSubnet sends the subnet of the first public address of the
+	// interfaces of the host, truncated to a /24 or /56, when ClientSubnet
+	// is nil. It is detected on the first query. No subnet is sent if the
+	// host has no public address, e.g. behind a NAT.
+	AutoClientSubnet bool
+
+	detector subnetDetector
+}
+
+// String returns URL, which names the resolver in EntryInfo.Source and
+// Upstream.
+func (r *DoHResolver) String() string {
+	return r.URL
+}
+
+// LookupHost looks up the A and AAAA records of host.
+func (r *DoHResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = r.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up the PTR records of addr.
+func (r *DoHResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = r.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the smallest TTL of the
+// answers.
+func (r *DoHResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	return lookupHostWire(ctx, r.exchange, host, r.wireOptions(ctx))
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the smallest TTL of the
+// answers.
+func (r *DoHResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	return lookupAddrWire(ctx, r.exchange, addr, r.wireOptions(ctx))
+}
+
+// LookupRecords looks up the CNAME, MX, SRV or TXT records of name.
+func (r *DoHResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	return lookupRecordsWire(ctx, r.exchange, rtype, name, r.wireOptions(ctx))
+}
+
+func (r *DoHResolver) wireOptions(ctx context.Context) wireOptions {
+	return wireOptions{
+		dnssec: r.RequireDNSSEC,
+		subnet: querySubnet(ctx, r.ClientSubnet, r.AutoClientSubnet, &r.detector),
+	}
+}
+
+func (r *DoHResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dnscache: DoH server %s returned %s", r.URL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+}
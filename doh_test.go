@@ -0,0 +1,68 @@
+package dnscache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestDoHServer(t *testing.T, handler testDNSHandler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := answerQuery(query, handler)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDoHResolver_LookupHostTTL(t *testing.T) {
+	srv := newTestDoHServer(t, testRawHandler)
+	r := &DoHResolver{URL: srv.URL + "/dns-query", Client: srv.Client()}
+
+	addrs, ttl, err := r.LookupHostTTL(context.Background(), "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 3 {
+		t.Errorf("addrs = %v, want 3 addresses", addrs)
+	}
+	if ttl != time.Minute {
+		t.Errorf("ttl = %v, want 1m0s", ttl)
+	}
+
+	names, err := r.LookupAddr(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "example.test." {
+		t.Errorf("names = %v, want [example.test.]", names)
+	}
+}
+
+func TestDoHResolver_HTTPError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	r := &DoHResolver{URL: srv.URL, Client: srv.Client()}
+
+	if _, err := r.LookupHost(context.Background(), "example.test"); err == nil {
+		t.Error("LookupHost succeeded on a failing server")
+	}
+}
@@ -0,0 +1,213 @@
+package dnscache
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultDoTIdleConns is the default of DoTResolver.MaxIdleConns.
+const defaultDoTIdleConns = 2
+
+// DoTResolver is a DNSResolver performing DNS over TLS lookups as specified
+// by RFC 7858. Connections are kept open and reused between lookups. It
+// supports A, AAAA, PTR, CNAME, MX, SRV and TXT lookups and implements
+// TTLResolver.
+type DoTResolver struct {
+	// Server is the address of the resolver in host:port form. If the port
+	// is omitted, 853 is used.
+	Server string
+
+	// ServerName is used to verify the certificate of the server. If empty,
+	// the host part of Server is used.
+	ServerName string
+
+	// TLSConfig is the base configuration of the TLS connections, e.g. to
+	// set RootCAs. It may be nil.
+	TLSConfig *tls.Config
+
+	// Timeout bounds a single exchange with the server, including
+	// connecting. If zero, only the lookup context deadline applies.
+	Timeout time.Duration
+
+	// MaxIdleConns is the number of connections kept open for reuse. If
+	// zero, 2 connections are kept. If negative, connections are not
+	// reused.
+	MaxIdleConns int
+
+	// Dialer is used to connect to the server. If nil, a zero net.Dialer is
+	// used.
+	Dialer *net.Dialer
+
+	// RequireDNSSEC makes lookups request DNSSEC validation from the server
+	// and fail with ErrUnauthenticatedAnswer when it does not vouch for
+	// the answer with the AD bit. The records are not validated locally,
+	// so the server must be a validating resolver reached over a path
+	// trusted not to tamper with the bit, as TLS is.
+	RequireDNSSEC bool
+
+	// ClientSubnet, if set, is sent with every query as EDNS Client Subnet
+	// (RFC 7871), so that servers of CDNs answer with addresses close to
+	// it. It should be truncated, e.g. to a /24 or /56, to not disclose
+	// the address of the host. ContextWithClientSubnet overrides it.
+	ClientSubnet *net.IPNet
+
+	// AutoClientSubnet sends the subnet of the first public address of the
+	// interfaces of the host, truncated to a /24 or /56, when ClientSubnet
+	// is nil. It is detected on the first query. No subnet is sent if the
+	// host has no public address, e.g. behind a NAT.
+	AutoClientSubnet bool
+
+	detector subnetDetector
+
+	mu   sync.Mutex
+	idle []net.Conn
+}
+
+// String returns Server prefixed with "tls://", which names the resolver
+// in EntryInfo.Source and Upstream.
+func (r *DoTResolver) String() string {
+	return "tls://" + r.Server
+}
+
+// LookupHost looks up the A and AAAA records of host.
+func (r *DoTResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = r.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up the PTR records of addr.
+func (r *DoTResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = r.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the smallest TTL of the
+// answers.
+func (r *DoTResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	return lookupHostWire(ctx, r.exchange, host, r.wireOptions(ctx))
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the smallest TTL of the
+// answers.
+func (r *DoTResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	return lookupAddrWire(ctx, r.exchange, addr, r.wireOptions(ctx))
+}
+
+// LookupRecords looks up the CNAME, MX, SRV or TXT records of name.
+func (r *DoTResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	return lookupRecordsWire(ctx, r.exchange, rtype, name, r.wireOptions(ctx))
+}
+
+// Close closes the idle connections.
+func (r *DoTResolver) Close() error {
+	r.mu.Lock()
+	idle := r.idle
+	r.idle = nil
+	r.mu.Unlock()
+	for _, conn := range idle {
+		conn.Close()
+	}
+	return nil
+}
+
+func (r *DoTResolver) wireOptions(ctx context.Context) wireOptions {
+	return wireOptions{
+		dnssec: r.RequireDNSSEC,
+		subnet: querySubnet(ctx, r.ClientSubnet, r.AutoClientSubnet, &r.detector),
+	}
+}
+
+func (r *DoTResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	// An idle connection may have been closed by the server in the meantime,
+	// in which case the query is retried once on a new connection.
+	if conn := r.getIdle(); conn != nil {
+		resp, err := r.exchangeConn(ctx, conn, query)
+		if err == nil || ctx.Err() != nil {
+			return resp, err
+		}
+	}
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.exchangeConn(ctx, conn, query)
+}
+
+// exchangeConn sends query over conn. The connection is put back in the idle
+// pool on success and closed otherwise.
+func (r *DoTResolver) exchangeConn(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	release := bindConn(ctx, conn)
+	err := writeStreamMessage(conn, query)
+	var resp []byte
+	if err == nil {
+		resp, err = readStreamMessage(conn)
+	}
+	release()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r.putIdle(conn)
+	return resp, nil
+}
+
+func (r *DoTResolver) dial(ctx context.Context) (net.Conn, error) {
+	server := r.Server
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+		server = net.JoinHostPort(server, "853")
+	}
+
+	var config *tls.Config
+	if r.TLSConfig != nil {
+		config = r.TLSConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if r.ServerName != "" {
+		config.ServerName = r.ServerName
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	d := tls.Dialer{NetDialer: r.Dialer, Config: config}
+	return d.DialContext(ctx, "tcp", server)
+}
+
+func (r *DoTResolver) getIdle() net.Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.idle) == 0 {
+		return nil
+	}
+	conn := r.idle[len(r.idle)-1]
+	r.idle = r.idle[:len(r.idle)-1]
+	return conn
+}
+
+func (r *DoTResolver) putIdle(conn net.Conn) {
+	max := r.MaxIdleConns
+	if max == 0 {
+		max = defaultDoTIdleConns
+	}
+	r.mu.Lock()
+	if len(r.idle) < max {
+		r.idle = append(r.idle, conn)
+		conn = nil
+	}
+	r.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package dnscache
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// startTestDoTServer serves handler over TLS on a local port. It returns a
+// resolver configured to trust the server and the number of connections the
+// server accepted.
+func startTestDoTServer(t *testing.T, handler testDNSHandler) (*DoTResolver, *int32) {
+	t.Helper()
+	// Borrow the test certificate of httptest, valid for example.com.
+	hs := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(hs.Close)
+	clientConfig := hs.Client().Transport.(*http.Transport).TLSClientConfig
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: hs.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					query, err := readStreamMessage(conn)
+					if err != nil {
+						return
+					}
+					resp, err := answerQuery(query, handler)
+					if err != nil {
+						return
+					}
+					if err = writeStreamMessage(conn, resp); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	r := &DoTResolver{
+		Server:     ln.Addr().String(),
+		ServerName: "example.com",
+		TLSConfig:  &tls.Config{RootCAs: clientConfig.RootCAs},
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, &accepted
+}
+
+func TestDoTResolver_ConnectionReuse(t *testing.T) {
+	r, accepted := startTestDoTServer(t, testRawHandler)
+
+	for i := 0; i < 3; i++ {
+		addrs, err := r.LookupHost(context.Background(), "example.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 3 {
+			t.Errorf("addrs = %v, want 3 addresses", addrs)
+		}
+	}
+	// The A and AAAA queries run concurrently, hence up to two connections.
+	if n := atomic.LoadInt32(accepted); n > 2 {
+		t.Errorf("server accepted %d connections, want at most 2", n)
+	}
+}
+
+func TestDoTResolver_ReconnectAfterClose(t *testing.T) {
+	r, accepted := startTestDoTServer(t, testRawHandler)
+
+	if _, err := r.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate the server closing the idle connection.
+	r.mu.Lock()
+	for _, conn := range r.idle {
+		conn.Close()
+	}
+	r.mu.Unlock()
+
+	names, err := r.LookupAddr(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "example.test." {
+		t.Errorf("names = %v, want [example.test.]", names)
+	}
+	if n := atomic.LoadInt32(accepted); n != 2 {
+		t.Errorf("server accepted %d connections, want 2", n)
+	}
+}
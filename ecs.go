@@ -0,0 +1,135 @@
+package dnscache
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ednsClientSubnet is the EDNS(0) option code of the client subnet option
+// (RFC 7871).
+const ednsClientSubnet = 8
+
+// Prefix lengths of the subnets detected by AutoClientSubnet, as
+// recommended by RFC 7871 section 11.1.
+const (
+	autoSubnetBits4 = 24
+	autoSubnetBits6 = 56
+)
+
+type clientSubnetKey struct{}
+
+// clientSubnet is the subnet attached to a context, along with its string
+// form keying the cache entries.
+type clientSubnet struct {
+	subnet *net.IPNet
+	key    string
+}
+
+// ContextWithClientSubnet returns a copy of ctx making the lookups through
+// it send subnet as EDNS Client Subnet, in place of the ClientSubnet of the
+// DoHResolver or DoTResolver upstream, e.g. for a proxy resolving names on
+// behalf of clients so that CDNs answer with addresses close to them. The
+// answers are cached apart from those of lookups for other subnets, or
+// without any. The other upstream resolvers ignore the subnet.
+func ContextWithClientSubnet(ctx context.Context, subnet *net.IPNet) context.Context {
+	if subnet == nil {
+		return ctx
+	}
+	masked := &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+	return context.WithValue(ctx, clientSubnetKey{}, clientSubnet{subnet: masked, key: masked.String()})
+}
+
+// contextSubnet returns the cache key of the client subnet of ctx, empty
+// if there is none.
+func contextSubnet(ctx context.Context) string {
+	cs, _ := ctx.Value(clientSubnetKey{}).(clientSubnet)
+	return cs.key
+}
+
+// withoutSubnet returns a copy of ctx without its client subnet, if any.
+func withoutSubnet(ctx context.Context) context.Context {
+	if contextSubnet(ctx) == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, clientSubnetKey{}, clientSubnet{})
+}
+
+// withSubnet returns ctx with the client subnet keying an entry, so that
+// it reaches the upstream lookup run with a context of its own.
+func withSubnet(ctx context.Context, key string) context.Context {
+	_, subnet, err := net.ParseCIDR(key)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientSubnetKey{}, clientSubnet{subnet: subnet, key: key})
+}
+
+// subnetDetector detects the subnet of AutoClientSubnet once.
+type subnetDetector struct {
+	once   sync.Once
+	subnet *net.IPNet
+}
+
+// detect returns the subnet of the first public unicast address of the
+// interfaces of the host, nil if there is none.
+func (d *subnetDetector) detect() *net.IPNet {
+	d.once.Do(func() {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+				continue
+			}
+			mask := net.CIDRMask(autoSubnetBits6, 128)
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				mask = net.CIDRMask(autoSubnetBits4, 32)
+			}
+			d.subnet = &net.IPNet{IP: ipNet.IP.Mask(mask), Mask: mask}
+			return
+		}
+	})
+	return d.subnet
+}
+
+// querySubnet returns the client subnet to send with the queries of a
+// lookup with ctx: the one of ctx, else configured, else the detected one
+// if auto.
+func querySubnet(ctx context.Context, configured *net.IPNet, auto bool, d *subnetDetector) *net.IPNet {
+	if cs, ok := ctx.Value(clientSubnetKey{}).(clientSubnet); ok && cs.subnet != nil {
+		return cs.subnet
+	}
+	if configured != nil {
+		return configured
+	}
+	if auto {
+		return d.detect()
+	}
+	return nil
+}
+
+// ecsOption returns the EDNS Client Subnet option carrying subnet, its
+// address truncated to the prefix length.
+func ecsOption(subnet *net.IPNet) dnsmessage.Option {
+	ip := subnet.IP.Mask(subnet.Mask)
+	ones, bits := subnet.Mask.Size()
+	family := uint16(2)
+	if ip4 := ip.To4(); ip4 != nil {
+		family, ip = 1, ip4
+		if bits == 128 {
+			ones -= 96
+		}
+	}
+	addr := ip[:(ones+7)/8]
+	data := make([]byte, 4+len(addr))
+	binary.BigEndian.PutUint16(data, family)
+	data[2] = byte(ones) // source prefix length, the scope one being 0
+	copy(data[4:], addr)
+	return dnsmessage.Option{Code: ednsClientSubnet, Data: data}
+}
@@ -0,0 +1,204 @@
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestECSOption(t *testing.T) {
+	for _, tc := range []struct {
+		cidr string
+		want []byte
+	}{
+		{"192.0.2.0/24", []byte{0, 1, 24, 0, 192, 0, 2}},
+		{"198.51.100.77/20", []byte{0, 1, 20, 0, 198, 51, 96}},
+		{"2001:db8:aa00::/56", []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0xaa, 0, 0}},
+		{"0.0.0.0/0", []byte{0, 1, 0, 0}},
+	} {
+		_, subnet, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt := ecsOption(subnet)
+		if opt.Code != ednsClientSubnet || !bytes.Equal(opt.Data, tc.want) {
+			t.Errorf("ecsOption(%s) = %d %v, want %d %v", tc.cidr, opt.Code, opt.Data, ednsClientSubnet, tc.want)
+		}
+	}
+}
+
+func TestQueryWire_ClientSubnet(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+	exchange := func(ctx context.Context, query []byte) ([]byte, error) {
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil {
+			return nil, err
+		}
+		var opts []dnsmessage.Option
+		if len(msg.Additionals) == 1 {
+			if opt, ok := msg.Additionals[0].Body.(*dnsmessage.OPTResource); ok {
+				opts = opt.Options
+			}
+		}
+		if len(opts) != 1 || opts[0].Code != ednsClientSubnet || msg.Additionals[0].Header.DNSSECAllowed() {
+			t.Errorf("query does not carry only the client subnet: %+v", msg.Additionals)
+		}
+		return answerQuery(query, testRawHandler)
+	}
+	addrs, _, err := lookupHostWire(context.Background(), exchange, "example.test", wireOptions{subnet: subnet})
+	if err != nil || len(addrs) != 3 {
+		t.Errorf("addrs = %v, err = %v, want 3 addresses", addrs, err)
+	}
+}
+
+// subnetResolver answers with the client subnet of the lookup context.
+type subnetResolver struct {
+	BadResolver
+	mu    sync.Mutex
+	calls int
+}
+
+func (r *subnetResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+	if subnet := querySubnet(ctx, nil, false, nil); subnet != nil {
+		return []string{subnet.IP.String()}, nil
+	}
+	return []string{"127.0.0.1"}, nil
+}
+
+func TestResolver_ClientSubnet(t *testing.T) {
+	upstream := &subnetResolver{}
+	r := &Resolver{Resolver: upstream}
+	_, europe, _ := net.ParseCIDR("192.0.2.0/24")
+	_, asia, _ := net.ParseCIDR("198.51.100.0/24")
+	ctxs := []context.Context{
+		context.Background(),
+		ContextWithClientSubnet(context.Background(), europe),
+		ContextWithClientSubnet(context.Background(), asia),
+	}
+	want := []string{"127.0.0.1", "192.0.2.0", "198.51.100.0"}
+
+	for round := 0; round < 2; round++ {
+		for i, ctx := range ctxs {
+			addrs, err := r.LookupHost(ctx, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(addrs) != 1 || addrs[0] != want[i] {
+				t.Errorf("round %d, lookup %d: addrs = %v, want [%s]", round, i, addrs, want[i])
+			}
+		}
+	}
+	if upstream.calls != len(ctxs) {
+		t.Errorf("upstream called %d times, want %d", upstream.calls, len(ctxs))
+	}
+
+	// Refreshes keep resolving each entry for its subnet.
+	r.Refresh()
+	for _, info := range r.Entries() {
+		if info.ClientSubnet == asia.String() && info.Records[0] != "198.51.100.0" {
+			t.Errorf("refreshed entry of %s holds %v", asia, info.Records)
+		}
+	}
+	if n := r.len(); n != len(ctxs) {
+		t.Errorf("cache holds %d entries, want %d", n, len(ctxs))
+	}
+}
+
+func TestResolver_ClientSubnetByName(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+	subnetCtx := ContextWithClientSubnet(context.Background(), subnet)
+	key := cacheKey{rtype: TypeHost, name: "example.com", subnet: subnet.String()}
+	newResolver := func(t *testing.T) *Resolver {
+		r := &Resolver{Resolver: &subnetResolver{}}
+		for _, ctx := range []context.Context{context.Background(), subnetCtx} {
+			if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r
+	}
+	cached := func(r *Resolver) *cacheEntry {
+		s := r.shard(key)
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.entries[key]
+	}
+
+	for name, remove := range map[string]func(*Resolver){
+		"Remove": func(r *Resolver) { r.Remove("example.com") },
+		"HandleInvalidation": func(r *Resolver) {
+			r.HandleInvalidation(Invalidation{Kind: HostRemoved, Host: "example.com"})
+		},
+		"Set": func(r *Resolver) { r.Set("example.com", []string{"10.0.0.1"}, time.Minute) },
+	} {
+		r := newResolver(t)
+		remove(r)
+		if cached(r) != nil {
+			t.Errorf("%s kept the entry of the client subnet", name)
+		}
+	}
+
+	r := newResolver(t)
+	r.Pin("example.com")
+	if entry := cached(r); entry == nil || !entry.pinned {
+		t.Error("Pin did not pin the entry of the client subnet")
+	}
+	r.Unpin("example.com")
+	if entry := cached(r); entry == nil || entry.pinned {
+		t.Error("Unpin did not unpin the entry of the client subnet")
+	}
+
+	// Watched hosts are kept for every subnet they are looked up for.
+	r = &Resolver{Resolver: &subnetResolver{}}
+	ctx, cancel := context.WithCancel(subnetCtx)
+	defer cancel()
+	if _, err := r.Watch(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupHost(subnetCtx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	r.Refresh()
+	r.Refresh()
+	if cached(r) == nil {
+		t.Error("Refresh purged the watched entry of the client subnet")
+	}
+}
+
+func TestResolver_ClientSubnetWatch(t *testing.T) {
+	var changes int
+	r := &Resolver{
+		Resolver: &subnetResolver{},
+		OnChange: func(host string, old, new []string) { changes++ },
+	}
+	defer r.Close()
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+	subnetCtx := ContextWithClientSubnet(context.Background(), subnet)
+
+	c, err := r.Watch(subnetCtx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs := <-c; len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("first addrs = %v, want [127.0.0.1]", addrs)
+	}
+	if _, err := r.LookupHost(subnetCtx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addrs := <-c:
+		t.Errorf("watcher got %v from a lookup with client subnet", addrs)
+	default:
+	}
+	if changes != 0 {
+		t.Errorf("OnChange called %d times for a lookup with client subnet", changes)
+	}
+}
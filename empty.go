@@ -0,0 +1,53 @@
+package dnscache
+
+import (
+	"net"
+	"time"
+)
+
+// EmptyResultPolicy is what to do with successful upstream answers holding
+// no record, as configured by Resolver.EmptyResultPolicy.
+type EmptyResultPolicy int
+
+const (
+	// CacheEmpty caches empty answers like any other, lookups returning no
+	// record and a nil error.
+	CacheEmpty EmptyResultPolicy = iota
+
+	// EmptyAsError makes lookups fail with a not found *net.DNSError
+	// instead, without caching anything.
+	EmptyAsError
+
+	// DontCacheEmpty returns empty answers as is but does not cache them,
+	// so that every lookup queries the upstream resolver until it returns
+	// records.
+	DontCacheEmpty
+
+	// NegativeCacheEmpty makes lookups fail like EmptyAsError and caches the
+	// error for Resolver.EmptyNegativeTTL.
+	NegativeCacheEmpty
+)
+
+// defaultEmptyNegativeTTL is used when Resolver.EmptyNegativeTTL is zero.
+const defaultEmptyNegativeTTL = 5 * time.Second
+
+// emptyResultMsg is the message of the errors of empty answers, telling
+// them apart from the not found answers of the upstream resolver.
+const emptyResultMsg = "no records"
+
+// emptyResult applies EmptyResultPolicy to lr, the empty answer of key.
+func (r *Resolver) emptyResult(key cacheKey, lr lookupResult) (lookupResult, error) {
+	switch r.EmptyResultPolicy {
+	case EmptyAsError, NegativeCacheEmpty:
+		return lr, &net.DNSError{Err: emptyResultMsg, Name: key.name, IsNotFound: true}
+	case DontCacheEmpty:
+		lr.noStore = true
+	}
+	return lr, nil
+}
+
+// isEmptyResult reports whether err is the error of an empty answer.
+func isEmptyResult(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.Err == emptyResultMsg
+}
@@ -0,0 +1,63 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_EmptyResultPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    EmptyResultPolicy
+		wantErr   bool
+		wantCalls int
+	}{
+		{"cache", CacheEmpty, false, 1},
+		{"error", EmptyAsError, true, 2},
+		{"dont cache", DontCacheEmpty, false, 2},
+		{"negative", NegativeCacheEmpty, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := dnscachetest.NewResolver()
+			upstream.SetHost("empty.example.com")
+			r := &Resolver{Resolver: upstream, EmptyResultPolicy: tt.policy}
+			for i := 0; i < 2; i++ {
+				addrs, err := r.LookupHost(context.Background(), "empty.example.com")
+				if len(addrs) != 0 {
+					t.Fatalf("addrs = %v, want none", addrs)
+				}
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("lookup %d: err = %v, want error %v", i, err, tt.wantErr)
+				}
+				if err != nil && !isNotFound(err) {
+					t.Errorf("err = %v, want a not found error", err)
+				}
+			}
+			if n := upstream.Calls("empty.example.com"); n != tt.wantCalls {
+				t.Errorf("upstream called %d times, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResolver_EmptyNegativeTTL(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("empty.example.com")
+	clock := newFakeClock()
+	r := &Resolver{Resolver: upstream, Clock: clock, EmptyResultPolicy: NegativeCacheEmpty, EmptyNegativeTTL: time.Minute}
+	_, _ = r.LookupHost(context.Background(), "empty.example.com")
+
+	upstream.SetHost("empty.example.com", "192.0.2.1")
+	if _, err := r.LookupHost(context.Background(), "empty.example.com"); err == nil {
+		t.Fatal("cached empty answer not served as an error")
+	}
+	clock.Advance(time.Minute + time.Second)
+	addrs, err := r.LookupHost(context.Background(), "empty.example.com")
+	if err != nil || len(addrs) != 1 {
+		t.Errorf("lookup after EmptyNegativeTTL: %v, %v, want the new address", addrs, err)
+	}
+}
@@ -0,0 +1,109 @@
+package dnscache
+
+import "time"
+
+// EntryInfo describes a cache entry, as reported by Entries and Range.
+type EntryInfo struct {
+	// Name is the host of a LookupHost entry, or the address of a
+	// LookupAddr entry when Reverse is true.
+	Name    string
+	Reverse bool
+
+	// Network is "ip4" or "ip6" for the entries of LookupNetHost limited to
+	// one address family, empty otherwise.
+	Network string
+
+	// Type is the type of the records, e.g. TypeHost for LookupHost
+	// entries or TypeMX for those of LookupRecords.
+	Type RecordType
+
+	// ClientSubnet is the subnet of the lookups of the entry, as given to
+	// ContextWithClientSubnet, empty for the lookups without any.
+	ClientSubnet string
+
+	// Records are the cached addresses, names for reverse entries, or
+	// records formatted as documented for their Type.
+	Records []string
+
+	// Age is how long ago the entry was first cached and LastRefresh when
+	// its records were last updated.
+	Age         time.Duration
+	LastRefresh time.Time
+
+	// Expires is when the records expire according to their TTL, zero if
+	// the resolver did not report one.
+	Expires time.Time
+
+	// LastError is the error of the last failed update of the entry, nil if
+	// the last update succeeded.
+	LastError error
+
+	// Hits is the number of lookups answered by the entry.
+	Hits uint64
+
+	// Used reports whether the entry was used since the last Refresh.
+	Used bool
+
+	// Source names the resolver which provided the records, and Upstream
+	// the upstream it got them from when it is made of several, e.g. the
+	// server of the RawResolver a FailoverResolver fell back to, so that
+	// answers served by a fallback stand out. Upstream equals Source for
+	// the resolvers not made of others, and is empty when unknown, e.g. for
+	// the entries loaded by Restore.
+	Source   string
+	Upstream string
+}
+
+// Entries returns a description of every cached entry, in no particular
+// order.
+func (r *Resolver) Entries() []EntryInfo {
+	infos := make([]EntryInfo, 0, r.len())
+	r.Range(func(info EntryInfo) bool {
+		infos = append(infos, info)
+		return true
+	})
+	return infos
+}
+
+// Range calls fn with a description of every cached entry until fn returns
+// false. The cache is not locked while fn runs.
+func (r *Resolver) Range(fn func(EntryInfo) bool) {
+	r.once.Do(r.init)
+	now := r.now()
+	var infos []EntryInfo
+	for i := range r.shards {
+		s := &r.shards[i]
+		infos = infos[:0]
+		s.mu.RLock()
+		for key, entry := range s.entries {
+			infos = append(infos, entry.info(key, now))
+		}
+		s.mu.RUnlock()
+
+		for _, info := range infos {
+			if !fn(info) {
+				return
+			}
+		}
+	}
+}
+
+// info describes entry. The shard of the entry must be locked.
+func (entry *cacheEntry) info(key cacheKey, now time.Time) EntryInfo {
+	return EntryInfo{
+		Name:         key.name,
+		Reverse:      key.rtype == TypePTR,
+		Network:      keyNetworks[key.rtype],
+		Type:         key.rtype,
+		ClientSubnet: key.subnet,
+		Records:      append([]string(nil), entry.rrs...),
+		Age:          now.Sub(entry.created),
+		LastRefresh:  entry.refreshed,
+		Expires:      entry.expires,
+		LastError:    entry.lastErr,
+		Hits:         entry.hits.Load(),
+		Used:         entry.used.Load(),
+		Source:       entry.source,
+		Upstream:     entry.upstream,
+	}
+}
@@ -0,0 +1,55 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolver_Entries(t *testing.T) {
+	upstream := &switchResolver{Resolver: BadResolver{}}
+	r := &Resolver{Resolver: upstream}
+	for i := 0; i < 3; i++ {
+		if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	upstream.Resolver = BadResolver{choke: true}
+	r.Refresh()
+
+	infos := r.Entries()
+	if len(infos) != 2 {
+		t.Fatalf("Entries() returned %d entries, want 2", len(infos))
+	}
+	for _, info := range infos {
+		if info.Reverse {
+			continue
+		}
+		if info.Name != "example.com" || len(info.Records) != 1 {
+			t.Errorf("info = %+v, want example.com with one record", info)
+		}
+		if info.Hits != 2 {
+			t.Errorf("Hits = %d, want 2", info.Hits)
+		}
+		if info.LastError == nil {
+			t.Error("LastError is nil after a failed refresh")
+		}
+		if info.Source != "*dnscache.switchResolver" {
+			t.Errorf("Source = %q, want *dnscache.switchResolver", info.Source)
+		}
+		if info.LastRefresh.IsZero() || info.Age <= 0 {
+			t.Errorf("LastRefresh, Age = %v, %v, want set", info.LastRefresh, info.Age)
+		}
+	}
+
+	n := 0
+	r.Range(func(EntryInfo) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Range called fn %d times after it returned false, want 1", n)
+	}
+}
@@ -0,0 +1,70 @@
+package dnscache
+
+// ExpiredPolicy is what a lookup does with a cached entry past its TTL. Only
+// entries of resolvers implementing TTLResolver, or restored from a
+// snapshot, have an expiry.
+type ExpiredPolicy int
+
+const (
+	// ResolveExpired makes the lookup wait for the entry to be resolved
+	// again, as on a miss. If the upstream lookup fails, the expired
+	// records are still served as allowed by Resolver.OnLookupError.
+	ResolveExpired ExpiredPolicy = iota
+
+	// ServeExpired answers the lookup with the expired records right away
+	// and resolves the entry again in the background, so that a later
+	// lookup gets the new answer.
+	ServeExpired
+)
+
+// revalidate resolves the entry of key again in the background, unless this
+// is already in progress or the resolver is closed.
+func (r *Resolver) revalidate(key cacheKey) {
+	s := r.shard(key)
+	s.mu.RLock()
+	entry := s.entries[key]
+	s.mu.RUnlock()
+	if entry == nil || r.ctx.Err() != nil || !entry.revalidating.CompareAndSwap(false, true) {
+		return
+	}
+	started := r.goTracked(func() {
+		if _, err := r.update(r.ctx, key, true, nil); err != nil {
+			entry.revalidating.Store(false)
+		}
+	})
+	if !started {
+		entry.revalidating.Store(false)
+	}
+}
+
+func (r *Resolver) expiredGCLoop(t Ticker) {
+	defer r.loops.Done()
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-t.C():
+			r.collectExpired()
+		}
+	}
+}
+
+// collectExpired deletes the entries expired for longer than ExpiredGrace.
+func (r *Resolver) collectExpired() {
+	before := r.now().Add(-r.ExpiredGrace)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		deleted := 0
+		for key, entry := range s.entries {
+			if !entry.expires.IsZero() && entry.expires.Before(before) && !entry.pinned {
+				s.deleteLocked(key, entry)
+				deleted++
+			}
+		}
+		s.mu.Unlock()
+		r.size.Add(-int64(deleted))
+		r.stats.expiredEvictions.Add(uint64(deleted))
+	}
+}
@@ -0,0 +1,75 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolver_ExpiredPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      ExpiredPolicy
+		wantMisses  uint64
+		wantLookups uint64
+	}{
+		{"resolve", ResolveExpired, 2, 2},
+		{"serve", ServeExpired, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			r := &Resolver{Resolver: ttlResolver{ttl: time.Minute}, ExpiredPolicy: tt.policy, Clock: clock}
+			defer r.Close()
+			for i := 0; i < 2; i++ {
+				if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(2 * time.Minute)
+			}
+			// Let the background revalidation of ServeExpired finish.
+			time.Sleep(10 * time.Millisecond)
+
+			stats := r.Stats()
+			if stats.Expired != 1 {
+				t.Errorf("Expired = %d, want 1", stats.Expired)
+			}
+			if stats.Misses != tt.wantMisses {
+				t.Errorf("Misses = %d, want %d", stats.Misses, tt.wantMisses)
+			}
+			if stats.Lookups != tt.wantLookups {
+				t.Errorf("Lookups = %d, want %d", stats.Lookups, tt.wantLookups)
+			}
+			if e := r.entry("hexample.com"); e == nil || e.revalidating.Load() {
+				t.Error("entry was not resolved again")
+			}
+		})
+	}
+}
+
+func TestResolver_ExpiredGC(t *testing.T) {
+	clock := newFakeClock()
+	r := &Resolver{Resolver: ttlResolver{ttl: time.Minute}, ExpiredGrace: time.Minute, Clock: clock}
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+	}
+
+	// a expired a minute ago, b just now: only a is past the grace period.
+	clock.Advance(time.Second)
+	r.collectExpired()
+	if r.entry("ha.example.com") != nil {
+		t.Error("entry expired past the grace period was kept")
+	}
+	if r.entry("hb.example.com") == nil {
+		t.Error("entry expired within the grace period was deleted")
+	}
+	if n := r.Stats().ExpiredEvictions; n != 1 {
+		t.Errorf("ExpiredEvictions = %d, want 1", n)
+	}
+	if n := r.size.Load(); n != 1 {
+		t.Errorf("size = %d, want 1", n)
+	}
+}
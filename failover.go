@@ -0,0 +1,189 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Defaults of FailoverResolver.MinBackoff and MaxBackoff.
+const (
+	defaultFailoverMinBackoff = time.Second
+	defaultFailoverMaxBackoff = time.Minute
+)
+
+var errNoUpstream = errors.New("dnscache: no upstream resolver configured")
+
+// FailoverResolver is a DNSResolver trying several upstream resolvers in
+// order until one of them answers. An upstream which fails is marked
+// unhealthy and only tried after the healthy ones until its backoff, which
+// doubles with every consecutive failure, has elapsed. A "no such host"
+// answer is authoritative and returned without trying other upstreams.
+type FailoverResolver struct {
+	// Resolvers are the upstreams, in order of preference.
+	Resolvers []DNSResolver
+
+	// Timeout bounds each attempt, so that a slow upstream does not use up
+	// the whole lookup deadline. If zero, only the lookup context deadline
+	// applies.
+	Timeout time.Duration
+
+	// MinBackoff is how long an upstream is considered unhealthy after its
+	// first failure. If zero, 1s is used.
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the backoff of an upstream failing repeatedly. If
+	// zero, 1m is used.
+	MaxBackoff time.Duration
+
+	mu     sync.Mutex
+	health []upstreamHealth
+}
+
+type upstreamHealth struct {
+	failures int
+	retryAt  time.Time
+}
+
+// LookupHost looks up host on the first upstream able to answer.
+func (f *FailoverResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = f.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up addr on the first upstream able to answer.
+func (f *FailoverResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = f.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the TTL reported by the
+// answering upstream, or NoTTL if it does not implement TTLResolver.
+func (f *FailoverResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	err = f.try(ctx, func(ctx context.Context, res DNSResolver) (err error) {
+		addrs, ttl, err = lookupHostTTL(ctx, res, host)
+		return
+	})
+	return
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the TTL reported by the
+// answering upstream, or NoTTL if it does not implement TTLResolver.
+func (f *FailoverResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	err = f.try(ctx, func(ctx context.Context, res DNSResolver) (err error) {
+		names, ttl, err = lookupAddrTTL(ctx, res, addr)
+		return
+	})
+	return
+}
+
+// LookupRecords looks up the records of name on the first upstream able to
+// answer.
+func (f *FailoverResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	err = f.try(ctx, func(ctx context.Context, res DNSResolver) (err error) {
+		rrs, ttl, err = lookupRecordsTTL(ctx, res, rtype, name)
+		return
+	})
+	return
+}
+
+// Healthy reports, for each upstream in Resolvers, whether it is currently
+// considered healthy.
+func (f *FailoverResolver) Healthy() []bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.initLocked()
+	now := time.Now()
+	healthy := make([]bool, len(f.health))
+	for i, h := range f.health {
+		healthy[i] = !now.Before(h.retryAt)
+	}
+	return healthy
+}
+
+// try calls fn with the upstreams in turn until one of them answers.
+func (f *FailoverResolver) try(ctx context.Context, fn func(ctx context.Context, res DNSResolver) error) error {
+	if len(f.Resolvers) == 0 {
+		return errNoUpstream
+	}
+	var err error
+	for _, i := range f.order() {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if f.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, f.Timeout)
+		}
+		attemptCtx, rec := nestUpstream(attemptCtx)
+		err = fn(attemptCtx, f.Resolvers[i])
+		cancel()
+		if err == nil || isNotFound(err) {
+			f.markHealthy(i)
+			reportUpstream(ctx, f.Resolvers[i], rec)
+			return err
+		}
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the health of
+			// the upstream, and trying other upstreams is pointless.
+			return err
+		}
+		f.markFailed(i)
+	}
+	return err
+}
+
+// order returns the indexes of the upstreams to try: the healthy ones in
+// order of preference followed by the unhealthy ones.
+func (f *FailoverResolver) order() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.initLocked()
+	now := time.Now()
+	order := make([]int, 0, len(f.health))
+	for i, h := range f.health {
+		if !now.Before(h.retryAt) {
+			order = append(order, i)
+		}
+	}
+	for i, h := range f.health {
+		if now.Before(h.retryAt) {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (f *FailoverResolver) markHealthy(i int) {
+	f.mu.Lock()
+	f.health[i] = upstreamHealth{}
+	f.mu.Unlock()
+}
+
+func (f *FailoverResolver) markFailed(i int) {
+	minBackoff, maxBackoff := f.MinBackoff, f.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultFailoverMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultFailoverMaxBackoff
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := &f.health[i]
+	backoff := minBackoff
+	for n := 0; n < h.failures && backoff < maxBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	h.failures++
+	h.retryAt = time.Now().Add(backoff)
+}
+
+// initLocked sizes the health table after Resolvers.
+func (f *FailoverResolver) initLocked() {
+	if len(f.health) != len(f.Resolvers) {
+		f.health = make([]upstreamHealth, len(f.Resolvers))
+	}
+}
@@ -0,0 +1,154 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestFailoverResolver(t *testing.T) {
+	failing := &fakeResolver{}
+	f := &FailoverResolver{
+		Resolvers:  []DNSResolver{failing, BadResolver{}},
+		MinBackoff: time.Hour,
+	}
+
+	addrs, err := f.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "216.58.192.238" {
+		t.Errorf("addrs = %v, want [216.58.192.238]", addrs)
+	}
+	if healthy := f.Healthy(); healthy[0] || !healthy[1] {
+		t.Errorf("Healthy() = %v, want [false true]", healthy)
+	}
+
+	// The unhealthy upstream is skipped while it backs off.
+	if _, err := f.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if failing.LookupHostCalls != 1 {
+		t.Errorf("failing upstream called %d times, want 1", failing.LookupHostCalls)
+	}
+}
+
+func TestFailoverResolver_NotFoundIsAuthoritative(t *testing.T) {
+	second := &fakeResolver{}
+	f := &FailoverResolver{Resolvers: []DNSResolver{
+		notFoundResolver{missing: map[string]bool{"missing.example.com": true}},
+		second,
+	}}
+
+	if _, err := f.LookupHost(context.Background(), "missing.example.com"); !isNotFound(err) {
+		t.Errorf("err = %v, want not found", err)
+	}
+	if second.LookupHostCalls != 0 {
+		t.Errorf("second upstream called %d times, want none", second.LookupHostCalls)
+	}
+}
+
+func TestFailoverResolver_Timeout(t *testing.T) {
+	f := &FailoverResolver{
+		Resolvers: []DNSResolver{&blockingResolver{}, BadResolver{}},
+		Timeout:   10 * time.Millisecond,
+	}
+	if _, err := f.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Errorf("LookupHost() = %v, want fallback to the second upstream", err)
+	}
+}
+
+func TestFailoverResolver_CallerCancelled(t *testing.T) {
+	f := &FailoverResolver{Resolvers: []DNSResolver{&blockingResolver{}, BadResolver{}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.LookupHost(ctx, "example.com"); err != context.DeadlineExceeded {
+		t.Errorf("LookupHost() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if healthy := f.Healthy(); !healthy[0] {
+		t.Errorf("Healthy() = %v, want the upstream the caller gave up on healthy", healthy)
+	}
+}
+
+func TestFailoverResolver_Backoff(t *testing.T) {
+	f := &FailoverResolver{
+		Resolvers:  []DNSResolver{&fakeResolver{}},
+		MinBackoff: time.Second,
+		MaxBackoff: 3 * time.Second,
+	}
+	f.initLocked()
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		f.markFailed(0)
+		if got := time.Until(f.health[0].retryAt); got > want || got < want-time.Second/2 {
+			t.Errorf("backoff = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestResolver_FailoverUpstream(t *testing.T) {
+	server := startTestDNSServer(t, testRawHandler)
+	f := &FailoverResolver{
+		Resolvers: []DNSResolver{
+			&fakeResolver{},
+			&SplitResolver{
+				Routes:  map[string]DNSResolver{"test": &RawResolver{Server: server, Timeout: time.Second}},
+				Default: BadResolver{},
+			},
+		},
+		MinBackoff: time.Hour,
+	}
+	r := &Resolver{Resolver: f, AuditSize: 10}
+	for _, host := range []string{"example.test", "example.com"} {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{"example.test": server, "example.com": "dnscache.BadResolver"}
+	for _, info := range r.Entries() {
+		if info.Source != "*dnscache.FailoverResolver" || info.Upstream != want[info.Name] {
+			t.Errorf("%s: Source = %q, Upstream = %q, want *dnscache.FailoverResolver and %q", info.Name, info.Source, info.Upstream, want[info.Name])
+		}
+	}
+	if events := r.RecentEvents(1); len(events) != 1 || events[0].Upstream != "dnscache.BadResolver" {
+		t.Errorf("RecentEvents(1) = %+v, want the upstream of example.com", events)
+	}
+	answers := r.Stats().UpstreamAnswers
+	if len(answers) != 2 || answers[server] != 1 || answers["dnscache.BadResolver"] != 1 {
+		t.Errorf("UpstreamAnswers = %v, want one answer of %s and of dnscache.BadResolver", answers, server)
+	}
+}
+
+func TestResolver_FailoverUpstreamTTL(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1")
+	f := &FailoverResolver{Resolvers: []DNSResolver{upstream}}
+	if _, ttl, err := f.LookupHostTTL(context.Background(), "example.com"); err != nil || ttl != NoTTL {
+		t.Errorf("LookupHostTTL() ttl = %v, err = %v, want %v", ttl, err, NoTTL)
+	}
+
+	// Without TTL, the entry has no expiry.
+	r := &Resolver{Resolver: f}
+	for i := 0; i < 5; i++ {
+		if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := upstream.Calls("example.com"); n != 2 {
+		t.Errorf("upstream called %d times, want 2", n)
+	}
+	if hits := r.Stats().Hits; hits != 4 {
+		t.Errorf("Hits = %d, want 4", hits)
+	}
+
+	// A TTL of zero still expires the entry right away.
+	r = &Resolver{Resolver: &FailoverResolver{Resolvers: []DNSResolver{ttlResolver{}}}}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if infos := r.Entries(); len(infos) != 1 || infos[0].Expires.IsZero() {
+		t.Errorf("Entries() = %+v, want one entry expiring", infos)
+	}
+}
@@ -2,4 +2,9 @@ module github.com/minio/dnscache
 
 go 1.19
 
-require golang.org/x/sync v0.0.0-20190423024810-112230192c58
+require (
+	golang.org/x/net v0.33.0
+	golang.org/x/sync v0.10.0
+)
+
+require golang.org/x/text v0.21.0 // indirect
@@ -0,0 +1,20 @@
+module github.com/minio/dnscache/grpcresolver
+
+// gRPC v1.84 requires Go 1.25. The grpcresolver module is separate so that
+// the root module keeps building with Go 1.19.
+go 1.25.0
+
+require (
+	github.com/minio/dnscache v0.0.0
+	google.golang.org/grpc v1.84.0
+)
+
+require (
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sync v0.22.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+)
+
+replace github.com/minio/dnscache => ../
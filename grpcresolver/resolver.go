@@ -0,0 +1,174 @@
+// Package grpcresolver provides a gRPC name resolver backed by a
+// dnscache.Resolver, so that gRPC clients share the cache of the rest of the
+// process instead of resolving names on their own schedule.
+//
+// Register a Builder and dial targets of the form "dnscache:///host:port":
+//
+//	resolver.Register(&grpcresolver.Builder{Resolver: r})
+//	conn, err := grpc.NewClient("dnscache:///backend.example.com:443", ...)
+package grpcresolver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/dnscache"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the default URI scheme of the targets resolved by a Builder.
+const Scheme = "dnscache"
+
+const (
+	defaultPort     = "443"
+	defaultInterval = 5 * time.Second
+)
+
+// Builder builds gRPC resolvers answering from Resolver. Each of them
+// watches its host with Resolver.Watch and pushes the addresses to gRPC
+// whenever they change, e.g. after a refresh of the cache.
+type Builder struct {
+	// Resolver resolves the target hosts. It must not be nil.
+	Resolver *dnscache.Resolver
+
+	// URIScheme is the scheme of the targets handled by the builder. If
+	// empty, Scheme is used.
+	URIScheme string
+
+	// Interval is how long the resolvers wait before looking up a host
+	// which failed to resolve again, unless gRPC asks for it sooner with
+	// ResolveNow. If zero, 5s is used.
+	Interval time.Duration
+}
+
+// Build implements resolver.Builder.
+func (b *Builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := net.SplitHostPort(target.Endpoint())
+	if err != nil {
+		host, port = target.Endpoint(), defaultPort
+	}
+	interval := b.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &ccResolver{
+		cache:    b.Resolver,
+		host:     host,
+		port:     port,
+		interval: interval,
+		cc:       cc,
+		ctx:      ctx,
+		cancel:   cancel,
+		now:      make(chan struct{}, 1),
+	}
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+// Scheme implements resolver.Builder.
+func (b *Builder) Scheme() string {
+	if b.URIScheme != "" {
+		return b.URIScheme
+	}
+	return Scheme
+}
+
+// ccResolver pushes the addresses of host to cc.
+type ccResolver struct {
+	cache    *dnscache.Resolver
+	host     string
+	port     string
+	interval time.Duration
+	cc       resolver.ClientConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	now    chan struct{}
+	wg     sync.WaitGroup
+}
+
+// ResolveNow implements resolver.Resolver.
+func (r *ccResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+// Close implements resolver.Resolver.
+func (r *ccResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *ccResolver) watch() {
+	defer r.wg.Done()
+	var last []string
+	for {
+		c, err := r.cache.Watch(r.ctx, r.host)
+		if err == nil {
+			for addrs := range c {
+				if !sameAddrs(addrs, last) {
+					last = sortedCopy(addrs)
+					r.update(addrs)
+				}
+			}
+			// c is closed once r is closed, or the cache is.
+			if r.ctx.Err() != nil {
+				return
+			}
+			r.cc.ReportError(dnscache.ErrClosed)
+			return
+		}
+		if r.ctx.Err() != nil {
+			return
+		}
+		r.cc.ReportError(err)
+		last = nil
+
+		t := time.NewTimer(r.interval)
+		select {
+		case <-r.ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		case <-r.now:
+			t.Stop()
+		}
+	}
+}
+
+// update pushes addrs to gRPC.
+func (r *ccResolver) update(addrs []string) {
+	state := resolver.State{Addresses: make([]resolver.Address, len(addrs))}
+	for i, addr := range addrs {
+		state.Addresses[i] = resolver.Address{Addr: net.JoinHostPort(addr, r.port)}
+	}
+	_ = r.cc.UpdateState(state)
+}
+
+// sameAddrs reports whether addrs holds the addresses of sorted, in any
+// order.
+func sameAddrs(addrs, sorted []string) bool {
+	if len(addrs) != len(sorted) {
+		return false
+	}
+	a := sortedCopy(addrs)
+	for i := range a {
+		if a[i] != sorted[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedCopy(addrs []string) []string {
+	a := append([]string(nil), addrs...)
+	sort.Strings(a)
+	return a
+}
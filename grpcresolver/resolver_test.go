@@ -0,0 +1,147 @@
+package grpcresolver
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache"
+	"google.golang.org/grpc/resolver"
+)
+
+// switchResolver answers host lookups with addrs, or fails them with err,
+// which tests change.
+type switchResolver struct {
+	mu    sync.Mutex
+	addrs []string
+	err   error
+}
+
+func (r *switchResolver) set(addrs ...string) {
+	r.mu.Lock()
+	r.addrs, r.err = addrs, nil
+	r.mu.Unlock()
+}
+
+func (r *switchResolver) fail(err error) {
+	r.mu.Lock()
+	r.addrs, r.err = nil, err
+	r.mu.Unlock()
+}
+
+func (r *switchResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addrs, r.err
+}
+
+func (r *switchResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return nil, nil
+}
+
+// testClientConn records the states pushed and the errors reported by a
+// resolver.
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) error {
+	cc.states <- s
+	return nil
+}
+
+func (cc *testClientConn) ReportError(err error) {
+	if cc.errs != nil {
+		cc.errs <- err
+	}
+}
+
+// wantState waits for cc to be pushed the single address addr.
+func wantState(t *testing.T, cc *testClientConn, addr string) {
+	t.Helper()
+	select {
+	case s := <-cc.states:
+		if len(s.Addresses) != 1 || s.Addresses[0].Addr != addr {
+			t.Errorf("UpdateState(%v), want [%s]", s.Addresses, addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no state pushed, want [%s]", addr)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	upstream := &switchResolver{}
+	upstream.set("192.0.2.1")
+	cache := &dnscache.Resolver{Resolver: upstream}
+	b := &Builder{Resolver: cache, Interval: time.Hour}
+	if b.Scheme() != Scheme {
+		t.Errorf("Scheme() = %q, want %q", b.Scheme(), Scheme)
+	}
+
+	cc := &testClientConn{states: make(chan resolver.State, 4)}
+	target := resolver.Target{URL: url.URL{Scheme: Scheme, Path: "/example.com:8080"}}
+	r, err := b.Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	wantState(t, cc, "192.0.2.1:8080")
+
+	// An unchanged address set is not pushed again, and a new one is
+	// pushed as soon as it is cached, without waiting for Interval.
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	if _, err := cache.ForceRefresh(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	upstream.set("192.0.2.2")
+	if _, err := cache.ForceRefresh(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	wantState(t, cc, "192.0.2.2:8080")
+	select {
+	case s := <-cc.states:
+		t.Errorf("UpdateState(%v) pushed twice", s.Addresses)
+	default:
+	}
+}
+
+func TestBuilder_Error(t *testing.T) {
+	upstream := &switchResolver{}
+	upstream.fail(errors.New("upstream down"))
+	cache := &dnscache.Resolver{Resolver: upstream}
+	b := &Builder{Resolver: cache, Interval: time.Hour}
+
+	cc := &testClientConn{states: make(chan resolver.State, 4), errs: make(chan error, 4)}
+	target := resolver.Target{URL: url.URL{Scheme: Scheme, Path: "/example.com:8080"}}
+	r, err := b.Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	select {
+	case <-cc.errs:
+	case <-time.After(time.Second):
+		t.Fatal("no error reported for a failing host")
+	}
+
+	// ResolveNow retries without waiting for Interval.
+	upstream.set("192.0.2.1")
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	wantState(t, cc, "192.0.2.1:8080")
+
+	// Closing the cache ends the watch.
+	_ = cache.Close()
+	select {
+	case err := <-cc.errs:
+		if err != dnscache.ErrClosed {
+			t.Errorf("ReportError(%v), want %v", err, dnscache.ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error reported once the cache was closed")
+	}
+}
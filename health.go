@@ -0,0 +1,144 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckPort    = 80
+	defaultHealthCheckTimeout = time.Second
+
+	// healthCheckConcurrency bounds the number of dials in flight during a
+	// health check.
+	healthCheckConcurrency = 16
+)
+
+func (r *Resolver) healthLoop(t Ticker) {
+	defer r.loops.Done()
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-t.C():
+			r.checkHealth(r.ctx)
+		}
+	}
+}
+
+// checkHealth dials every address cached for a host and reorders the
+// entries according to the addresses found unreachable.
+func (r *Resolver) checkHealth(ctx context.Context) {
+	addrs := make(map[string]bool)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		for key, entry := range s.entries {
+			if !isHostType(key.rtype) {
+				continue
+			}
+			for _, addr := range entry.answer {
+				addrs[addr] = false
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	port := r.HealthCheckPort
+	if port == 0 {
+		port = defaultHealthCheckPort
+	}
+	timeout := r.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		sem         = make(chan struct{}, healthCheckConcurrency)
+		unreachable = make(map[string]bool)
+	)
+	for addr := range addrs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(addr string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if !dialHealthy(ctx, net.JoinHostPort(addr, strconv.Itoa(port)), timeout) {
+				r.stats.healthCheckFailures.Add(1)
+				mu.Lock()
+				unreachable[addr] = true
+				mu.Unlock()
+			}
+		}(addr)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.unreachable.Store(&unreachable)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if isHostType(key.rtype) {
+				entry.rrs = r.serveOrder(entry.answer)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func dialHealthy(ctx context.Context, address string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// healthOrder returns addrs with the addresses found unreachable by the last
+// health check moved last, or removed with HealthCheckPrune. addrs itself is
+// returned if all of them are reachable.
+func (r *Resolver) healthOrder(addrs []string) []string {
+	p := r.unreachable.Load()
+	if p == nil || len(*p) == 0 {
+		return addrs
+	}
+	unreachable := *p
+	down := 0
+	for _, addr := range addrs {
+		if unreachable[addr] {
+			down++
+		}
+	}
+	if down == 0 || (down == len(addrs) && r.HealthCheckPrune) {
+		return addrs
+	}
+
+	ordered := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !unreachable[addr] {
+			ordered = append(ordered, addr)
+		}
+	}
+	if !r.HealthCheckPrune {
+		for _, addr := range addrs {
+			if unreachable[addr] {
+				ordered = append(ordered, addr)
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,69 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// addrsResolver answers every host lookup with addrs.
+type addrsResolver struct {
+	BadResolver
+	addrs []string
+}
+
+func (r addrsResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.addrs, nil
+}
+
+func TestResolver_HealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	tests := []struct {
+		name  string
+		prune bool
+		want  []string
+	}{
+		{"deprioritize", false, []string{"127.0.0.1", "127.0.0.2"}},
+		{"prune", true, []string{"127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Resolver{
+				Resolver:         addrsResolver{addrs: []string{"127.0.0.2", "127.0.0.1"}},
+				HealthCheckPort:  portNum,
+				HealthCheckPrune: tt.prune,
+			}
+			ctx := context.Background()
+			if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+				t.Fatal(err)
+			}
+			r.checkHealth(ctx)
+
+			addrs, err := r.LookupHost(ctx, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.want) {
+				t.Errorf("LookupHost = %v, want %v", addrs, tt.want)
+			}
+			if n := r.Stats().HealthCheckFailures; n != 1 {
+				t.Errorf("HealthCheckFailures = %d, want 1", n)
+			}
+
+			// Refreshed answers keep the unreachable address demoted.
+			r.Refresh()
+			if addrs, _ := r.Peek("example.com"); !reflect.DeepEqual(addrs, tt.want) {
+				t.Errorf("after Refresh, Peek = %v, want %v", addrs, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package dnscache
+
+import "time"
+
+// IdleInfo describes a cache entry not used since the previous Refresh, as
+// passed to Resolver.UnusedPolicy.
+type IdleInfo struct {
+	// Cycles is the number of consecutive refreshes, including the current
+	// one, which found the entry unused.
+	Cycles int
+
+	// Duration is the time elapsed since the first of those refreshes.
+	Duration time.Duration
+}
+
+// KeepUnusedCycles returns an UnusedPolicy keeping unused entries for n
+// refreshes before deleting them.
+func KeepUnusedCycles(n int) func(IdleInfo) bool {
+	return func(idle IdleInfo) bool {
+		return idle.Cycles <= n
+	}
+}
+
+// KeepUnusedFor returns an UnusedPolicy keeping unused entries until they
+// have been idle for d, as observed by successive refreshes.
+func KeepUnusedFor(d time.Duration) func(IdleInfo) bool {
+	return func(idle IdleInfo) bool {
+		return idle.Duration < d
+	}
+}
+
+// NeverEvictUnused is an UnusedPolicy keeping every entry, however long it
+// has not been used. Combine it with MaxEntries to bound the cache.
+func NeverEvictUnused(IdleInfo) bool {
+	return true
+}
@@ -0,0 +1,40 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolver_UnusedPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy func(IdleInfo) bool
+		want   int // refreshes until the entry is deleted, 0 for never
+	}{
+		{"default", nil, 2},
+		{"cycles", KeepUnusedCycles(2), 4},
+		{"age", KeepUnusedFor(time.Hour), 0},
+		{"expired age", KeepUnusedFor(0), 2},
+		{"never", NeverEvictUnused, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Resolver{Resolver: BadResolver{}, UnusedPolicy: tt.policy}
+			if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+				t.Fatal(err)
+			}
+			got := 0
+			for i := 1; i <= 5; i++ {
+				r.Refresh()
+				if r.entry("hexample.com") == nil {
+					got = i
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("entry deleted by refresh %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
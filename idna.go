@@ -0,0 +1,33 @@
+package dnscache
+
+import (
+	"net"
+
+	"golang.org/x/net/idna"
+)
+
+// toASCII converts an internationalized host name to its A-label form, e.g.
+// "bücher.example" to "xn--bcher-kva.example", so that both spellings share
+// a cache entry and resolvers only accepting ASCII can resolve it. ASCII
+// names are returned unchanged without allocating.
+func toASCII(host string) (string, error) {
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			ascii, err := idna.Lookup.ToASCII(host)
+			if err != nil {
+				return "", &net.DNSError{Err: "invalid domain name: " + err.Error(), Name: host}
+			}
+			return ascii, nil
+		}
+	}
+	return host, nil
+}
+
+// asciiName is like toASCII but returns invalid names unchanged, for APIs
+// which cannot fail.
+func asciiName(host string) string {
+	if ascii, err := toASCII(host); err == nil {
+		return ascii
+	}
+	return host
+}
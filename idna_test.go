@@ -0,0 +1,39 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	for host, want := range map[string]string{
+		"example.com":           "example.com",
+		"bücher.example":        "xn--bcher-kva.example",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+	} {
+		got, err := toASCII(host)
+		if err != nil {
+			t.Errorf("toASCII(%s): %v", host, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("toASCII(%s) = %s, want %s", host, got, want)
+		}
+	}
+}
+
+func TestResolver_IDNSharesEntry(t *testing.T) {
+	f := &slowResolver{}
+	r := &Resolver{Resolver: f}
+	for _, host := range []string{"bücher.example", "xn--bcher-kva.example"} {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.calls != 1 {
+		t.Errorf("upstream called %d times, want 1", f.calls)
+	}
+	if r.entry("hxn--bcher-kva.example") == nil {
+		t.Error("entry not cached under its A-label")
+	}
+}
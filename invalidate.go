@@ -0,0 +1,50 @@
+package dnscache
+
+// InvalidationKind is the reason of an Invalidation.
+type InvalidationKind int
+
+const (
+	// HostRemoved is published when the entry of a host is deleted with
+	// Remove.
+	HostRemoved InvalidationKind = iota
+
+	// HostChanged is published when the addresses of a host are replaced by
+	// a different set, e.g. after a DNS failover.
+	HostChanged
+)
+
+// Invalidation is an event about the cached addresses of a host, exchanged
+// with the other members of a fleet through an Invalidator.
+type Invalidation struct {
+	Kind InvalidationKind
+	Host string
+
+	// Addrs are the new addresses of a HostChanged event.
+	Addrs []string
+}
+
+// Invalidator is the sink of the invalidation events of a Resolver, see
+// Resolver.Invalidator. An implementation typically broadcasts them to the
+// other nodes of a fleet, which pass them to their own resolver with
+// HandleInvalidation.
+type Invalidator interface {
+	// Publish is called synchronously by the goroutine which updated or
+	// removed the entry and must not block.
+	Publish(Invalidation)
+}
+
+// HandleInvalidation applies an invalidation received from another node:
+// the entries of the host, for every client subnet, are removed, so that
+// the next lookup resolves it again, typically getting the addresses the
+// other node switched to. It is not published back to Invalidator.
+func (r *Resolver) HandleInvalidation(inv Invalidation) {
+	r.once.Do(r.init)
+	r.removeHost(asciiName(inv.Host))
+}
+
+// publish sends inv to Invalidator, if set.
+func (r *Resolver) publish(kind InvalidationKind, host string, addrs []string) {
+	if r.Invalidator != nil {
+		r.Invalidator.Publish(Invalidation{Kind: kind, Host: host, Addrs: addrs})
+	}
+}
@@ -0,0 +1,58 @@
+package dnscache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+// fleetInvalidator records the published invalidations and forwards them
+// to peers.
+type fleetInvalidator struct {
+	published []Invalidation
+	peers     []*Resolver
+}
+
+func (f *fleetInvalidator) Publish(inv Invalidation) {
+	f.published = append(f.published, inv)
+	for _, peer := range f.peers {
+		peer.HandleInvalidation(inv)
+	}
+}
+
+func TestResolver_Invalidator(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1")
+	peer := &Resolver{Resolver: upstream}
+	sink := &fleetInvalidator{peers: []*Resolver{peer}}
+	r := &Resolver{Resolver: upstream, Invalidator: sink}
+	ctx := context.Background()
+	for _, res := range []*Resolver{r, peer} {
+		if _, err := res.LookupHost(ctx, "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upstream.SetHost("example.com", "192.0.2.2")
+	r.Refresh()
+	want := []Invalidation{{Kind: HostChanged, Host: "example.com", Addrs: []string{"192.0.2.2"}}}
+	if !reflect.DeepEqual(sink.published, want) {
+		t.Errorf("published %+v, want %+v", sink.published, want)
+	}
+	if _, ok := peer.Peek("example.com"); ok {
+		t.Error("peer still caches the changed host")
+	}
+	if addrs, _ := peer.LookupHost(ctx, "example.com"); !reflect.DeepEqual(addrs, []string{"192.0.2.2"}) {
+		t.Errorf("peer addrs = %v, want [192.0.2.2]", addrs)
+	}
+
+	r.Remove("example.com")
+	if n := len(sink.published); n != 2 || sink.published[1].Kind != HostRemoved {
+		t.Errorf("published %+v, want a HostRemoved event last", sink.published)
+	}
+	if _, ok := peer.Peek("example.com"); ok {
+		t.Error("peer still caches the removed host")
+	}
+}
@@ -0,0 +1,138 @@
+package dnscache
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// scoreDecay is the weight of the latest result in the moving averages
+	// of addrScore.
+	scoreDecay = 0.3
+
+	// failurePenalty is the latency added to the score of an address which
+	// always fails, scaled down with its failure rate.
+	failurePenalty = time.Second
+)
+
+// addrScore is the moving average of the results reported for an address.
+type addrScore struct {
+	rtt      time.Duration
+	failRate float64
+}
+
+// score returns the sort key of the address, lower being better.
+func (s *addrScore) score() time.Duration {
+	return s.rtt + time.Duration(s.failRate*float64(failurePenalty))
+}
+
+// ReportResult reports the outcome of a connection to ip, an address of
+// host: err is the dial error, nil on success, and rtt how long the dial
+// took. With OrderByLatency, the cached addresses of host are reordered
+// accordingly. A Dialer reports its results itself.
+func (r *Resolver) ReportResult(host, ip string, err error, rtt time.Duration) {
+	r.once.Do(r.init)
+	r.scoresMu.Lock()
+	s := r.scores[ip]
+	if s == nil {
+		s = &addrScore{rtt: rtt}
+		if err != nil {
+			s.rtt = 0
+			s.failRate = 1
+		}
+		r.scores[ip] = s
+	} else if err != nil {
+		s.failRate = (1-scoreDecay)*s.failRate + scoreDecay
+	} else {
+		s.rtt = time.Duration((1-scoreDecay)*float64(s.rtt) + scoreDecay*float64(rtt))
+		s.failRate *= 1 - scoreDecay
+	}
+	r.scoresMu.Unlock()
+
+	if !r.OrderByLatency {
+		return
+	}
+	key := cacheKey{rtype: TypeHost, name: asciiName(host)}
+	sh := r.shard(key)
+	sh.mu.Lock()
+	if entry, found := sh.entries[key]; found {
+		entry.rrs = r.serveOrder(entry.answer)
+	}
+	sh.mu.Unlock()
+}
+
+// serveOrder returns the order in which the addresses of an answer are
+// served, according to health checks and OrderByLatency.
+func (r *Resolver) serveOrder(addrs []string) []string {
+	addrs = r.healthOrder(addrs)
+	if !r.OrderByLatency || len(addrs) < 2 {
+		return addrs
+	}
+
+	scores := make([]time.Duration, len(addrs))
+	known := false
+	r.scoresMu.Lock()
+	for i, addr := range addrs {
+		// Addresses without results score 0, so that they get tried.
+		if s := r.scores[addr]; s != nil {
+			scores[i] = s.score()
+			known = true
+		}
+	}
+	r.scoresMu.Unlock()
+	if !known {
+		return addrs
+	}
+
+	order := make([]int, len(addrs))
+	for i := range order {
+		order[i] = i
+	}
+	unreachable := r.unreachable.Load()
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		// Keep the addresses demoted by health checks last.
+		if unreachable != nil {
+			if di, dj := (*unreachable)[addrs[i]], (*unreachable)[addrs[j]]; di != dj {
+				return dj
+			}
+		}
+		return scores[i] < scores[j]
+	})
+	sorted := make([]string, len(addrs))
+	for i, idx := range order {
+		sorted[i] = addrs[idx]
+	}
+	return sorted
+}
+
+// pruneScores forgets the results of the addresses no longer cached.
+func (r *Resolver) pruneScores() {
+	r.scoresMu.Lock()
+	n := len(r.scores)
+	r.scoresMu.Unlock()
+	if n == 0 {
+		return
+	}
+
+	cached := make(map[string]bool)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		for key, entry := range s.entries {
+			if isHostType(key.rtype) {
+				for _, addr := range entry.answer {
+					cached[addr] = true
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+	r.scoresMu.Lock()
+	for addr := range r.scores {
+		if !cached[addr] {
+			delete(r.scores, addr)
+		}
+	}
+	r.scoresMu.Unlock()
+}
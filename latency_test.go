@@ -0,0 +1,45 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolver_OrderByLatency(t *testing.T) {
+	ctx := context.Background()
+	r := &Resolver{
+		Resolver:       addrsResolver{addrs: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}},
+		OrderByLatency: true,
+	}
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	r.ReportResult("example.com", "192.0.2.1", nil, 50*time.Millisecond)
+	r.ReportResult("example.com", "192.0.2.2", errors.New("connection refused"), time.Millisecond)
+	r.ReportResult("example.com", "192.0.2.3", nil, 10*time.Millisecond)
+
+	want := []string{"192.0.2.3", "192.0.2.1", "192.0.2.2"}
+	addrs, err := r.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Errorf("LookupHost = %v, want %v", addrs, want)
+	}
+
+	// Refreshed answers keep the order.
+	r.Refresh()
+	if addrs, _ := r.Peek("example.com"); !reflect.DeepEqual(addrs, want) {
+		t.Errorf("after Refresh, Peek = %v, want %v", addrs, want)
+	}
+
+	// Results of addresses no longer cached are dropped.
+	r.Remove("example.com")
+	r.Refresh()
+	if n := len(r.scores); n != 0 {
+		t.Errorf("%d scores kept, want 0", n)
+	}
+}
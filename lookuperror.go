@@ -0,0 +1,29 @@
+package dnscache
+
+import "log"
+
+// LookupErrorPolicy is what a lookup does when its upstream query fails,
+// as configured by Resolver.OnLookupError.
+type LookupErrorPolicy int
+
+const (
+	// ServeStale answers with the records cached from a previous lookup,
+	// if any, hiding the error.
+	ServeStale LookupErrorPolicy = iota
+
+	// ReturnError always returns the error, so that callers never use
+	// addresses which could not be confirmed.
+	ReturnError
+
+	// ServeStaleAndLog is like ServeStale but logs the error to
+	// Resolver.ErrorLog.
+	ServeStaleAndLog
+)
+
+func (r *Resolver) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,52 @@
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolver_OnLookupError(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  LookupErrorPolicy
+		wantErr bool
+		wantLog bool
+	}{
+		{"serve stale", ServeStale, false, false},
+		{"return error", ReturnError, true, false},
+		{"serve stale and log", ServeStaleAndLog, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			upstream := &switchResolver{Resolver: BadResolver{}}
+			r := &Resolver{
+				Resolver:      upstream,
+				OnLookupError: tt.policy,
+				ErrorLog:      log.New(&buf, "", 0),
+			}
+			ctx := context.Background()
+			if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+				t.Fatal(err)
+			}
+
+			// Expire the entry so that the next lookup goes upstream.
+			upstream.Resolver = BadResolver{choke: true}
+			r.Remove("example.com")
+			r.storeExpiring(cacheKey{rtype: TypeHost, name: "example.com"},
+				lookupResult{rrs: []string{"192.0.2.1"}}, time.Now().Add(-time.Second), true)
+
+			_, err := r.LookupHost(ctx, "example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if logged := strings.Contains(buf.String(), "example.com"); logged != tt.wantLog {
+				t.Errorf("logged %q, want log %v", buf.String(), tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package dnscache
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// AddrSubset selects the addresses kept for a host answering more than
+// Resolver.MaxAddrsPerEntry of them.
+type AddrSubset int
+
+const (
+	// KeepFirst keeps the first addresses, in the order given by AddrOrder
+	// and DNS64.
+	KeepFirst AddrSubset = iota
+
+	// KeepBothFamilies keeps the first addresses like KeepFirst, but at
+	// least one of each family the host has, so that dialers can fall back
+	// from one family to the other.
+	KeepBothFamilies
+
+	// KeepRandom keeps a random subset, in the order of the answer, so that
+	// the clients of a fleet spread over all the addresses. A new subset is
+	// drawn on every refresh, which OnChange, Watch and Invalidator report
+	// as a change.
+	KeepRandom
+)
+
+// limitAddrs returns at most max addresses of addrs, selected by subset.
+func limitAddrs(addrs []string, max int, subset AddrSubset) []string {
+	if len(addrs) <= max {
+		return addrs
+	}
+	switch subset {
+	case KeepBothFamilies:
+		limited := append([]string(nil), addrs[:max]...)
+		if max < 2 {
+			return limited
+		}
+		isV6 := func(addr string) bool { return strings.Contains(addr, ":") }
+		hasV4, hasV6 := false, false
+		for _, addr := range limited {
+			hasV4, hasV6 = hasV4 || !isV6(addr), hasV6 || isV6(addr)
+		}
+		if hasV4 && hasV6 {
+			return limited
+		}
+		// All the kept addresses are of one family: replace the last one with
+		// the first address of the other family, if any.
+		for _, addr := range addrs[max:] {
+			if isV6(addr) != isV6(limited[0]) {
+				limited[max-1] = addr
+				break
+			}
+		}
+		return limited
+	case KeepRandom:
+		keep := rand.Perm(len(addrs))[:max]
+		selected := make([]bool, len(addrs))
+		for _, i := range keep {
+			selected[i] = true
+		}
+		limited := make([]string, 0, max)
+		for i, addr := range addrs {
+			if selected[i] {
+				limited = append(limited, addr)
+			}
+		}
+		return limited
+	default:
+		return append([]string(nil), addrs[:max]...)
+	}
+}
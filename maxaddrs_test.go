@@ -0,0 +1,60 @@
+package dnscache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLimitAddrs(t *testing.T) {
+	addrs := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1", "2001:db8::2"}
+	tests := []struct {
+		name   string
+		max    int
+		subset AddrSubset
+		want   []string
+	}{
+		{"under", 5, KeepFirst, addrs},
+		{"first", 2, KeepFirst, []string{"192.0.2.1", "192.0.2.2"}},
+		{"both families", 2, KeepBothFamilies, []string{"192.0.2.1", "2001:db8::1"}},
+		{"both families single", 1, KeepBothFamilies, []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limitAddrs(addrs, tt.max, tt.subset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("limitAddrs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got := limitAddrs(addrs, 3, KeepRandom)
+	if len(got) != 3 || !isSubsequence(got, addrs) {
+		t.Errorf("limitAddrs(KeepRandom) = %v, want 3 addresses in the order of %v", got, addrs)
+	}
+}
+
+// isSubsequence reports whether sub holds elements of s in the same order.
+func isSubsequence(sub, s []string) bool {
+	i := 0
+	for _, e := range s {
+		if i < len(sub) && sub[i] == e {
+			i++
+		}
+	}
+	return i == len(sub)
+}
+
+func TestResolver_MaxAddrsPerEntry(t *testing.T) {
+	upstream := addrsResolver{addrs: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}}
+	r := &Resolver{Resolver: upstream, MaxAddrsPerEntry: 2}
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := upstream.addrs[:2]; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addrs = %v, want %v", addrs, want)
+	}
+	if cached, _ := r.Peek("example.com"); len(cached) != 2 {
+		t.Errorf("cached %v, want 2 addresses", cached)
+	}
+}
@@ -0,0 +1,196 @@
+package dnscache
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mDNS defaults, see MDNSResolver.
+const (
+	mdnsGroup          = "224.0.0.251:5353"
+	defaultMDNSTimeout = time.Second
+	defaultMDNSMaxTTL  = 10 * time.Second
+
+	// mdnsFamilyGrace is how long a host lookup waits for the answer of the
+	// other address family once one answered, as responders stay silent
+	// about the records they do not have.
+	mdnsFamilyGrace = 100 * time.Millisecond
+)
+
+// MDNSResolver is a DNSResolver performing multicast DNS lookups as
+// specified by RFC 6762, for names under .local. It sends one-shot queries
+// and implements TTLResolver. Names without any answer within Timeout are
+// reported as not found. It is typically routed the "local" domain of a
+// SplitResolver:
+//
+//	r := &dnscache.Resolver{Resolver: &dnscache.SplitResolver{
+//		Routes: map[string]dnscache.DNSResolver{"local": &dnscache.MDNSResolver{}},
+//	}}
+type MDNSResolver struct {
+	// Addr is the address the queries are sent to. If empty, the IPv4 mDNS
+	// group 224.0.0.251:5353 is used.
+	Addr string
+
+	// Timeout is how long to wait for an answer. If zero, 1s is used.
+	Timeout time.Duration
+
+	// MaxTTL caps the TTL reported for the answers, as peers on a local
+	// link come and go without the cache hearing about it. If zero, 10s is
+	// used, the TTL of one-shot answers in RFC 6762 section 6.7. If
+	// negative, the TTLs of the answers are used.
+	MaxTTL time.Duration
+}
+
+// LookupHost looks up the A and AAAA records of host.
+func (r *MDNSResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = r.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up the PTR records of addr.
+func (r *MDNSResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = r.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the smallest TTL of the
+// answers, capped by MaxTTL.
+func (r *MDNSResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+
+	type answer struct {
+		rrs []string
+		ttl time.Duration
+		err error
+	}
+	answers := make(chan answer, 2)
+	for _, qtype := range [...]dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		go func(qtype dnsmessage.Type) {
+			var a answer
+			a.rrs, a.ttl, a.err = queryWire(ctx, r.exchange, host, qtype, wireOptions{})
+			answers <- a
+		}(qtype)
+	}
+
+	var grace <-chan time.Time
+collect:
+	for pending := 2; pending > 0; pending-- {
+		var a answer
+		select {
+		case a = <-answers:
+		case <-grace:
+			break collect
+		}
+		if len(a.rrs) == 0 {
+			if err == nil {
+				err = a.err
+			}
+			continue
+		}
+		if len(addrs) == 0 || a.ttl < ttl {
+			ttl = a.ttl
+		}
+		addrs = append(addrs, a.rrs...)
+		if grace == nil {
+			t := time.NewTimer(mdnsFamilyGrace)
+			defer t.Stop()
+			grace = t.C
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, r.capTTL(ttl), nil
+	}
+	return nil, 0, r.notFound(parent, host, err)
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the smallest TTL of the
+// answers, capped by MaxTTL.
+func (r *MDNSResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+	names, ttl, err = lookupAddrWire(ctx, r.exchange, addr, wireOptions{})
+	if err != nil {
+		return nil, 0, r.notFound(parent, addr, err)
+	}
+	return names, r.capTTL(ttl), nil
+}
+
+func (r *MDNSResolver) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return defaultMDNSTimeout
+}
+
+func (r *MDNSResolver) capTTL(ttl time.Duration) time.Duration {
+	maxTTL := r.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = defaultMDNSMaxTTL
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// notFound returns the error of a lookup of name which got no answer: as
+// nobody answering is how mDNS says a name does not exist, running out of
+// Timeout is a not found error, unless the caller context is done.
+func (r *MDNSResolver) notFound(parent context.Context, name string, err error) error {
+	if err == nil || (err == context.DeadlineExceeded || isTimeout(err)) && parent.Err() == nil {
+		return errNoSuchHost(name)
+	}
+	return err
+}
+
+// exchange sends query to the mDNS group from an ephemeral port, which makes
+// responders answer with a unicast response echoing the query ID (RFC 6762
+// section 6.7), and returns the first such response.
+func (r *MDNSResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	addr := r.Addr
+	if addr == "" {
+		addr = mdnsGroup
+	}
+	group, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer bindConn(ctx, conn)()
+
+	if _, err = conn.WriteTo(query, group); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(query)
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Other responses may reach the port, e.g. multicast ones.
+		if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+			return buf[:n], nil
+		}
+	}
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,71 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startTestMDNSResponder answers the A queries of printer.local. and, like an
+// mDNS responder, does not answer the others.
+func startTestMDNSResponder(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if msg.Unpack(buf[:n]) != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			if q.Name.String() != "printer.local." || q.Type != dnsmessage.TypeA {
+				continue
+			}
+			resp, err := answerQuery(buf[:n], func(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+				return []dnsmessage.Resource{
+					testResource("printer.local.", 120, &dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}}),
+				}, dnsmessage.RCodeSuccess
+			})
+			if err == nil {
+				_, _ = pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestMDNSResolver(t *testing.T) {
+	r := &MDNSResolver{Addr: startTestMDNSResponder(t), Timeout: 200 * time.Millisecond}
+
+	start := time.Now()
+	addrs, ttl, err := r.LookupHostTTL(context.Background(), "printer.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.168.1.20" {
+		t.Errorf("addrs = %v, want [192.168.1.20]", addrs)
+	}
+	if ttl != defaultMDNSMaxTTL {
+		t.Errorf("ttl = %v, want %v", ttl, defaultMDNSMaxTTL)
+	}
+	if d := time.Since(start); d >= r.Timeout {
+		t.Errorf("lookup took %v, want it not to wait for the AAAA answer", d)
+	}
+
+	_, err = r.LookupHost(context.Background(), "scanner.local")
+	if !isNotFound(err) {
+		t.Errorf("lookup of unanswered name: err = %v, want not found", err)
+	}
+}
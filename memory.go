@@ -0,0 +1,114 @@
+package dnscache
+
+import (
+	"math/rand"
+	"time"
+	"unsafe"
+)
+
+const (
+	// entryOverhead approximates the memory of a cache entry besides its
+	// name and records: the entry itself, its map slot and the key.
+	entryOverhead = int64(unsafe.Sizeof(cacheEntry{})) + 64
+
+	// lruSamples is the number of entries compared to pick the least
+	// recently used one to evict, as sampling avoids maintaining a list
+	// under a global lock.
+	lruSamples = 16
+
+	// touchResolution bounds how often lastUsed is written for a hot
+	// entry.
+	touchResolution = int64(time.Second)
+)
+
+// entrySize returns the approximate memory used by an entry of key holding
+// answer and served.
+func entrySize(key cacheKey, answer, served []string) int64 {
+	n := entryOverhead + int64(len(key.name))
+	for _, rr := range answer {
+		n += int64(unsafe.Sizeof(rr)) + int64(len(rr))
+	}
+	if len(served) > 0 && len(answer) > 0 && &served[0] != &answer[0] {
+		// A health ordered copy only adds the slice, the strings are
+		// shared.
+		n += int64(len(served)) * int64(unsafe.Sizeof(""))
+	}
+	return n
+}
+
+// touch records that the entry was used at now.
+func (e *cacheEntry) touch(now time.Time) {
+	ns := now.UnixNano()
+	if ns-e.lastUsed.Load() >= touchResolution {
+		e.lastUsed.Store(ns)
+	}
+}
+
+// memory returns the approximate memory used by the cached entries.
+func (r *Resolver) memory() (n int64) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		n += s.bytes
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// evictLRU evicts entries other than keep until the cache fits in MaxMemory.
+func (r *Resolver) evictLRU(keep cacheKey) {
+	for r.memory() > r.MaxMemory {
+		if !r.evictOldest(keep) {
+			return
+		}
+		r.size.Add(-1)
+		r.stats.evictions.Add(1)
+	}
+}
+
+// evictOldest deletes the least recently used of lruSamples entries taken
+// from random shards, and reports whether one was deleted.
+func (r *Resolver) evictOldest(keep cacheKey) bool {
+	var (
+		victim   cacheKey
+		oldest   int64
+		shardIdx = -1
+		sampled  int
+	)
+	start := rand.Intn(shardCount)
+	for i := 0; i < shardCount && sampled < lruSamples; i++ {
+		idx := (start + i) % shardCount
+		s := &r.shards[idx]
+		s.mu.RLock()
+		n := 0
+		for key, entry := range s.entries {
+			if key == keep || entry.pinned {
+				continue
+			}
+			if used := entry.lastUsed.Load(); shardIdx < 0 || used < oldest {
+				victim, oldest, shardIdx = key, used, idx
+			}
+			sampled++
+			// Take a few entries per shard so that samples come from
+			// several of them.
+			if n++; n == lruSamples/4 {
+				break
+			}
+		}
+		s.mu.RUnlock()
+	}
+	if shardIdx < 0 {
+		return false
+	}
+
+	s := &r.shards[shardIdx]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, found := s.entries[victim]
+	if !found {
+		// Deleted concurrently, which helps too.
+		return false
+	}
+	s.deleteLocked(victim, entry)
+	return true
+}
@@ -0,0 +1,38 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolver_MaxMemory(t *testing.T) {
+	ctx := context.Background()
+	size := entrySize(cacheKey{rtype: TypeHost, name: "host10.example.com"}, []string{"216.58.192.238"}, nil)
+	r := &Resolver{Resolver: BadResolver{}, MaxMemory: 10 * size}
+	hosts := benchmarkHosts(30)
+	if _, err := r.LookupHost(ctx, hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range hosts[1:] {
+		// Make the first host the most recently used one.
+		if e := r.entry("h" + hosts[0]); e != nil {
+			e.lastUsed.Store(1 << 62)
+		}
+		if _, err := r.LookupHost(ctx, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if m := r.memory(); m > r.MaxMemory {
+		t.Errorf("cache uses %d bytes, want at most %d", m, r.MaxMemory)
+	}
+	if n := r.len(); int64(n) != r.size.Load() {
+		t.Errorf("size = %d, want %d", r.size.Load(), n)
+	}
+	if r.Stats().Evictions == 0 {
+		t.Error("Evictions = 0, want some")
+	}
+	if _, ok := r.Peek(hosts[0]); !ok {
+		t.Error("most recently used entry was evicted")
+	}
+}
@@ -0,0 +1,68 @@
+package dnscache
+
+import "time"
+
+// cachesNegative reports whether err, returned by the lookup of key, is
+// cached as a negative entry.
+func (r *Resolver) cachesNegative(key cacheKey, err error) bool {
+	_, ok := r.negativeTTL(key, err)
+	return ok
+}
+
+// negativeTTL returns how long err, returned by the lookup of key, is
+// cached, according to ReverseNegativeTTL and EmptyResultPolicy.
+func (r *Resolver) negativeTTL(key cacheKey, err error) (ttl time.Duration, ok bool) {
+	if r.EmptyResultPolicy == NegativeCacheEmpty && isEmptyResult(err) {
+		if r.EmptyNegativeTTL > 0 {
+			return r.EmptyNegativeTTL, true
+		}
+		return defaultEmptyNegativeTTL, true
+	}
+	if key.rtype == TypePTR && r.ReverseNegativeTTL > 0 && isNotFound(err) {
+		return r.ReverseNegativeTTL, true
+	}
+	return 0, false
+}
+
+// storeNegative caches err, returned by f, as the answer of key for its
+// negative TTL, if it is a negative answer to cache, and reports whether it
+// is.
+func (r *Resolver) storeNegative(key cacheKey, f *flight, err error, used bool) bool {
+	ttl, ok := r.negativeTTL(key, err)
+	if !ok {
+		return false
+	}
+	r.storeExpiring(key, lookupResult{err: err, flight: f}, r.now().Add(ttl), used)
+	return true
+}
+
+// negativeErr returns the cached error of key if its entry is negative.
+func (r *Resolver) negativeErr(key cacheKey) error {
+	s := r.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if entry, found := s.entries[key]; found {
+		return entry.negErr
+	}
+	return nil
+}
+
+// FlushNegative deletes the negative entries, cached for reverse lookups or
+// empty answers, so that the next lookups of their names query the
+// upstream resolver.
+func (r *Resolver) FlushNegative() {
+	r.once.Do(r.init)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		deleted := 0
+		for key, entry := range s.entries {
+			if entry.negErr != nil {
+				s.deleteLocked(key, entry)
+				deleted++
+			}
+		}
+		s.mu.Unlock()
+		r.size.Add(-int64(deleted))
+	}
+}
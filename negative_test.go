@@ -0,0 +1,50 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_ReverseNegativeTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	upstream := dnscachetest.NewResolver()
+	r := &Resolver{Resolver: upstream, ReverseNegativeTTL: time.Minute, Clock: clock}
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.LookupAddr(ctx, "192.0.2.1"); !isNotFound(err) {
+			t.Fatalf("LookupAddr err = %v, want not found", err)
+		}
+	}
+	if n := upstream.Calls("192.0.2.1"); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+	if n := r.Stats().NegativeHits; n != 2 {
+		t.Errorf("NegativeHits = %d, want 2", n)
+	}
+
+	// Refreshes keep the negative entry, until the address gets a name.
+	r.Refresh()
+	if r.entry("r192.0.2.1") == nil {
+		t.Fatal("negative entry was evicted by Refresh")
+	}
+	upstream.SetAddr("192.0.2.1", "host.example.com.")
+	clock.Advance(2 * time.Minute)
+	names, err := r.LookupAddr(ctx, "192.0.2.1")
+	if err != nil || len(names) != 1 {
+		t.Errorf("LookupAddr after expiry = %v, %v, want [host.example.com.]", names, err)
+	}
+
+	upstream.SetAddrError("192.0.2.2", dnscachetest.NotFound("192.0.2.2"))
+	_, _ = r.LookupAddr(ctx, "192.0.2.2")
+	r.FlushNegative()
+	if r.entry("r192.0.2.2") != nil {
+		t.Error("FlushNegative kept a negative entry")
+	}
+	if r.entry("r192.0.2.1") == nil {
+		t.Error("FlushNegative deleted a positive entry")
+	}
+}
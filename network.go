@@ -0,0 +1,51 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// keyNetworks maps the record types of single family lookups to their network.
+var keyNetworks = map[RecordType]string{
+	TypeA:    "ip4",
+	TypeAAAA: "ip6",
+}
+
+// LookupNetHost is like LookupHost but only returns the addresses of the
+// family of network, which must be "ip", "ip4" or "ip6" as for
+// net.Resolver.LookupIP. The addresses of each family are cached apart from
+// those of LookupHost and refreshed on their own, so that an answer of one
+// family is never served for another. The upstream resolver is still asked
+// for the addresses of both families, then filtered. It fails with a not
+// found error if host has no address of the family.
+func (r *Resolver) LookupNetHost(ctx context.Context, network, host string) (addrs []string, err error) {
+	var rtype RecordType
+	switch network {
+	case "ip":
+		return r.LookupHost(ctx, host)
+	case "ip4":
+		rtype = TypeA
+	case "ip6":
+		rtype = TypeAAAA
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	r.once.Do(r.init)
+	if host, err = toASCII(host); err != nil {
+		return nil, err
+	}
+	addrs, err = r.lookup(ctx, cacheKey{rtype: rtype, name: host}, 0, nil)
+	return r.shuffle(host, addrs), err
+}
+
+// filterFamily returns the addresses of addrs of the family of rtype.
+func filterFamily(addrs []string, rtype RecordType) []string {
+	var filtered []string
+	for _, addr := range addrs {
+		if strings.Contains(addr, ":") == (rtype == TypeAAAA) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
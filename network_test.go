@@ -0,0 +1,62 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_LookupNetHost(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1", "2001:db8::1")
+	upstream.SetHost("v4.example.com", "192.0.2.2")
+	r := &Resolver{Resolver: upstream}
+	ctx := context.Background()
+
+	tests := []struct {
+		network string
+		want    []string
+	}{
+		{"ip4", []string{"192.0.2.1"}},
+		{"ip6", []string{"2001:db8::1"}},
+		{"ip", []string{"192.0.2.1", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		addrs, err := r.LookupNetHost(ctx, tt.network, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameAddrs(addrs, tt.want) {
+			t.Errorf("LookupNetHost(%q) = %v, want %v", tt.network, addrs, tt.want)
+		}
+	}
+	if n := r.len(); n != 3 {
+		t.Errorf("cache holds %d entries, want one per network", n)
+	}
+
+	// Each family is refreshed on its own.
+	upstream.Reset()
+	upstream.SetHost("example.com", "192.0.2.3", "2001:db8::1")
+	r.Refresh()
+	if n := upstream.Calls("example.com"); n != 3 {
+		t.Errorf("refresh made %d upstream calls, want 3", n)
+	}
+	if addrs, _ := r.LookupNetHost(ctx, "ip4", "example.com"); !sameAddrs(addrs, []string{"192.0.2.3"}) {
+		t.Errorf("ip4 addrs after refresh = %v, want [192.0.2.3]", addrs)
+	}
+
+	_, err := r.LookupNetHost(ctx, "ip6", "v4.example.com")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("ip6 lookup of IPv4-only host: err = %v, want not found", err)
+	}
+	if _, err := r.LookupNetHost(ctx, "tcp", "example.com"); err == nil {
+		t.Error("lookup of unknown network succeeded")
+	}
+
+	r.Remove("example.com")
+	if n := r.len(); n != 0 {
+		t.Errorf("cache holds %d entries after Remove, want 0", n)
+	}
+}
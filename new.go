@@ -0,0 +1,75 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Option configures a Resolver created by New.
+type Option func(*options)
+
+type options struct {
+	r     *Resolver
+	seeds []string
+}
+
+// WithResolver sets the upstream resolver, see Resolver.Resolver.
+func WithResolver(resolver DNSResolver) Option {
+	return func(o *options) { o.r.Resolver = resolver }
+}
+
+// WithPreferGo makes the system resolver use the Go resolver, see
+// Resolver.PreferGo.
+func WithPreferGo() Option {
+	return func(o *options) { o.r.PreferGo = true }
+}
+
+// WithStrictErrors makes the system resolver fail lookups on temporary
+// errors, see Resolver.StrictErrors.
+func WithStrictErrors() Option {
+	return func(o *options) { o.r.StrictErrors = true }
+}
+
+// WithDial sets the function connecting the system resolver to the
+// nameservers, see Resolver.Dial.
+func WithDial(dial func(ctx context.Context, network, address string) (net.Conn, error)) Option {
+	return func(o *options) { o.r.Dial = dial }
+}
+
+// WithTimeout sets the timeout of upstream lookups, see Resolver.Timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.r.Timeout = d }
+}
+
+// WithRefreshInterval sets the interval of background refreshes, see
+// Resolver.RefreshInterval.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(o *options) { o.r.RefreshInterval = d }
+}
+
+// WithSeedHosts makes New resolve hosts before returning, failing if any
+// of them cannot be resolved, so that a service whose critical endpoints
+// are unresolvable fails at startup instead of on its first request.
+func WithSeedHosts(hosts ...string) Option {
+	return func(o *options) { o.seeds = append(o.seeds, hosts...) }
+}
+
+// New returns a Resolver configured by opts. Seed hosts are resolved
+// concurrently, bounded by ctx; if some fail, the resolver is closed and
+// a *WarmError listing them is returned.
+func New(ctx context.Context, opts ...Option) (*Resolver, error) {
+	o := options{r: &Resolver{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := o.r
+	r.once.Do(r.init)
+	if len(o.seeds) > 0 {
+		if err := r.Warm(ctx, o.seeds...); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,49 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestNew(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("a.example.com", "192.0.2.1")
+	upstream.SetHost("b.example.com", "192.0.2.2")
+	r, err := New(context.Background(),
+		WithResolver(upstream),
+		WithTimeout(time.Second),
+		WithSeedHosts("a.example.com", "b.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Timeout != time.Second {
+		t.Errorf("Timeout = %v, want 1s", r.Timeout)
+	}
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		if _, ok := r.Peek(host); !ok {
+			t.Errorf("seed host %s is not cached", host)
+		}
+	}
+}
+
+func TestNew_SeedFailure(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("a.example.com", "192.0.2.1")
+	upstream.SetHostError("b.example.com", dnscachetest.NotFound("b.example.com"))
+	r, err := New(context.Background(), WithResolver(upstream), WithSeedHosts("a.example.com", "b.example.com"))
+	if r != nil {
+		t.Error("New returned a resolver along with the seed error")
+	}
+	var warmErr *WarmError
+	if !errors.As(err, &warmErr) {
+		t.Fatalf("err = %v, want a *WarmError", err)
+	}
+	if len(warmErr.Errors) != 1 || warmErr.Errors["b.example.com"] == nil {
+		t.Errorf("failed hosts = %v, want b.example.com only", warmErr.Errors)
+	}
+}
@@ -0,0 +1,93 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+)
+
+// LookupOption changes how a single lookup uses the cache. Options can be
+// combined with |; NoCache takes precedence over ForceFresh, which takes
+// precedence over CacheOnly. Entries set with SetStatic are always honored.
+type LookupOption uint8
+
+const (
+	// NoCache bypasses the cache entirely: the upstream resolver is asked
+	// and its answer is neither shared with concurrent lookups nor cached.
+	NoCache LookupOption = 1 << iota
+
+	// CacheOnly answers from the cache and never asks the upstream
+	// resolver. A miss returns ErrNotCached.
+	CacheOnly
+
+	// ForceFresh asks the upstream resolver even if the host is cached and
+	// caches the answer. A failure is returned rather than hidden behind
+	// the stale records.
+	ForceFresh
+)
+
+// ErrNotCached is returned by a CacheOnly lookup of a name which is not
+// cached.
+var ErrNotCached = errors.New("dnscache: not cached")
+
+// LookupHostWith is like LookupHost with the given options applied to this
+// lookup only.
+func (r *Resolver) LookupHostWith(ctx context.Context, host string, opts ...LookupOption) (addrs []string, err error) {
+	r.once.Do(r.init)
+	if host, err = toASCII(host); err != nil {
+		return nil, err
+	}
+	addrs, err = r.lookup(ctx, cacheKey{rtype: TypeHost, name: host}, combineOptions(opts), nil)
+	return r.shuffle(host, addrs), err
+}
+
+// LookupAddrWith is like LookupAddr with the given options applied to this
+// lookup only.
+func (r *Resolver) LookupAddrWith(ctx context.Context, addr string, opts ...LookupOption) (names []string, err error) {
+	r.once.Do(r.init)
+	return r.lookup(ctx, cacheKey{rtype: TypePTR, name: addr}, combineOptions(opts), nil)
+}
+
+func combineOptions(opts []LookupOption) (o LookupOption) {
+	for _, opt := range opts {
+		o |= opt
+	}
+	return o
+}
+
+// lookupUncached looks up key upstream without going through the
+// singleflight group or the cache, giving up once ctx is done.
+func (r *Resolver) lookupUncached(ctx context.Context, key cacheKey) (rrs []string, err error) {
+	type result struct {
+		lr  lookupResult
+		err error
+	}
+	fn := r.lookupFunc(ctx, key)
+	c := make(chan result, 1)
+	r.flightsMu.Lock()
+	r.running++
+	r.flightsMu.Unlock()
+	go func() {
+		defer func() {
+			r.flightsMu.Lock()
+			r.endRunningLocked()
+			r.flightsMu.Unlock()
+		}()
+		r.stats.lookups.Add(1)
+		v, err := fn()
+		if err != nil {
+			r.stats.lookupErrors.Add(1)
+		}
+		lr, _ := v.(lookupResult)
+		c <- result{lr, err}
+	}()
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			r.stats.timeouts.Add(1)
+		}
+		return nil, err
+	case res := <-c:
+		return res.lr.rrs, res.err
+	}
+}
@@ -0,0 +1,46 @@
+package dnscache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResolver_LookupOptions(t *testing.T) {
+	ctx := context.Background()
+	f := &slowResolver{}
+	upstream := &switchResolver{Resolver: f}
+	r := &Resolver{Resolver: upstream}
+
+	if _, err := r.LookupHostWith(ctx, "example.com", CacheOnly); err != ErrNotCached {
+		t.Fatalf("CacheOnly miss: err = %v, want %v", err, ErrNotCached)
+	}
+	if _, err := r.LookupHostWith(ctx, "example.com", NoCache); err != nil {
+		t.Fatal(err)
+	}
+	if r.entry("hexample.com") != nil {
+		t.Error("NoCache lookup was cached")
+	}
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupHostWith(ctx, "example.com", CacheOnly); err != nil {
+		t.Fatalf("CacheOnly hit: %v", err)
+	}
+	if _, err := r.LookupHostWith(ctx, "example.com", ForceFresh); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 3 {
+		t.Errorf("upstream called %d times, want 3", calls)
+	}
+
+	// ForceFresh reports upstream failures instead of the stale records,
+	// which a regular lookup of a cached host never reaches.
+	upstream.Resolver = BadResolver{choke: true}
+	if _, err := r.LookupHostWith(ctx, "example.com", ForceFresh); err == nil {
+		t.Error("ForceFresh served stale records on upstream failure")
+	}
+	if addrs, err := r.LookupHost(ctx, "example.com"); err != nil || len(addrs) == 0 {
+		t.Errorf("LookupHost = %v, %v, want cached records", addrs, err)
+	}
+}
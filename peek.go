@@ -0,0 +1,22 @@
+package dnscache
+
+// Peek returns the addresses cached for host, including static ones,
+// without querying the upstream resolver on a miss and without counting as
+// a use of the entry. It lets health endpoints and debug tooling inspect the
+// cache without side effects. Only the entry of lookups without client
+// subnet is reported, not those of ContextWithClientSubnet.
+func (r *Resolver) Peek(host string) (addrs []string, ok bool) {
+	r.once.Do(r.init)
+	host = asciiName(host)
+	if addrs, ok = r.loadStatic(host); !ok {
+		addrs, ok = r.peek(cacheKey{rtype: TypeHost, name: host})
+	}
+	return r.results(addrs), ok
+}
+
+// PeekAddr is like Peek for the names cached by LookupAddr.
+func (r *Resolver) PeekAddr(addr string) (names []string, ok bool) {
+	r.once.Do(r.init)
+	names, ok = r.peek(cacheKey{rtype: TypePTR, name: addr})
+	return r.results(names), ok
+}
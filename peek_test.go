@@ -0,0 +1,33 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolver_Peek(t *testing.T) {
+	f := &fakeResolver{}
+	r := &Resolver{Resolver: f}
+	if _, ok := r.Peek("example.com"); ok {
+		t.Error("Peek found an entry in an empty cache")
+	}
+	if f.LookupHostCalls != 0 {
+		t.Errorf("Peek queried upstream %d times", f.LookupHostCalls)
+	}
+
+	r.Resolver = BadResolver{}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	r.Refresh() // clears the used flag
+	addrs, ok := r.Peek("example.com")
+	if !ok || len(addrs) != 1 {
+		t.Errorf("Peek() = %v, %v, want cached address", addrs, ok)
+	}
+	if r.entry("hexample.com").used.Load() {
+		t.Error("Peek marked the entry used")
+	}
+	if _, ok := r.PeekAddr("192.0.2.1"); ok {
+		t.Error("PeekAddr found an entry never looked up")
+	}
+}
@@ -0,0 +1,54 @@
+package dnscache
+
+// Pin keeps host in the cache and refreshed by Refresh whether or not it is
+// used, and protects it from eviction by MaxEntries, MaxMemory, the expired
+// entry collection and RefreshErrorPolicy, e.g. for critical endpoints.
+// Remove and Flush still delete it. Pin does not look host up: it is cached
+// by its next lookup if it is not already.
+func (r *Resolver) Pin(host string) {
+	r.setPinned(host, true)
+}
+
+// Unpin reverts Pin, so that host is handled like any other entry again.
+func (r *Resolver) Unpin(host string) {
+	r.setPinned(host, false)
+}
+
+func (r *Resolver) setPinned(host string, pinned bool) {
+	r.once.Do(r.init)
+	host = asciiName(host)
+	r.pinsMu.Lock()
+	pins := make(map[string]bool)
+	if old := r.pins.Load(); old != nil {
+		for name := range *old {
+			pins[name] = true
+		}
+	}
+	if pinned {
+		pins[host] = true
+	} else {
+		delete(pins, host)
+	}
+	r.pins.Store(&pins)
+	r.pinsMu.Unlock()
+
+	for _, rtype := range [...]RecordType{TypeHost, TypeA, TypeAAAA} {
+		for _, key := range r.keysOf(rtype, host) {
+			s := r.shard(key)
+			s.mu.Lock()
+			if entry, found := s.entries[key]; found {
+				entry.pinned = pinned
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// isPinned reports whether key is the key of a pinned host.
+func (r *Resolver) isPinned(key cacheKey) bool {
+	if !isHostType(key.rtype) {
+		return false
+	}
+	pins := r.pins.Load()
+	return pins != nil && (*pins)[key.name]
+}
@@ -0,0 +1,48 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_Pin(t *testing.T) {
+	ctx := context.Background()
+	upstream := dnscachetest.NewResolver()
+	hosts := benchmarkHosts(5)
+	for _, host := range hosts {
+		upstream.SetHost(host, "192.0.2.1")
+	}
+	r := &Resolver{Resolver: upstream, MaxEntries: 2}
+	r.Pin(hosts[0])
+	for _, host := range hosts {
+		if _, err := r.LookupHost(ctx, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.entry("h"+hosts[0]) == nil {
+		t.Fatal("pinned entry was evicted by MaxEntries")
+	}
+
+	// Unused, the pinned entry is still refreshed rather than purged, even
+	// when its name disappears.
+	upstream.Reset()
+	upstream.SetHostError(hosts[0], dnscachetest.NotFound(hosts[0]))
+	for i := 0; i < 3; i++ {
+		r.Refresh()
+	}
+	if r.entry("h"+hosts[0]) == nil {
+		t.Error("pinned entry was purged")
+	}
+	if n := upstream.Calls(hosts[0]); n != 3 {
+		t.Errorf("pinned entry refreshed %d times, want 3", n)
+	}
+
+	r.Unpin(hosts[0])
+	r.Refresh()
+	r.Refresh()
+	if r.entry("h"+hosts[0]) != nil {
+		t.Error("unpinned entry was kept")
+	}
+}
@@ -0,0 +1,131 @@
+package dnscache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// raceLatencyWeight is the weight of a new sample in the moving average of
+// the upstream latencies recorded by RaceResolver.
+const raceLatencyWeight = 0.2
+
+// RaceResolver is a DNSResolver sending each lookup to all its upstream
+// resolvers in parallel and returning the first answer, cancelling the
+// other lookups. Like with FailoverResolver, a "no such host" answer is
+// authoritative. It is useful for multi-region deployments where the
+// nearest resolver varies.
+type RaceResolver struct {
+	// Resolvers are the upstreams queried for every lookup.
+	Resolvers []DNSResolver
+
+	// RecordLatency enables tracking of a moving average of the latency of
+	// each upstream, reported by Latencies.
+	RecordLatency bool
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+type raceAnswer struct {
+	index    int
+	rrs      []string
+	ttl      time.Duration
+	err      error
+	upstream *upstreamRecorder
+}
+
+// LookupHost looks up host on all upstreams and returns the first answer.
+func (r *RaceResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = r.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up addr on all upstreams and returns the first answer.
+func (r *RaceResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = r.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the TTL reported by the
+// winning upstream, or NoTTL if it does not implement TTLResolver.
+func (r *RaceResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	return r.race(ctx, func(ctx context.Context, res DNSResolver) ([]string, time.Duration, error) {
+		return lookupHostTTL(ctx, res, host)
+	})
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the TTL reported by the
+// winning upstream, or NoTTL if it does not implement TTLResolver.
+func (r *RaceResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	return r.race(ctx, func(ctx context.Context, res DNSResolver) ([]string, time.Duration, error) {
+		return lookupAddrTTL(ctx, res, addr)
+	})
+}
+
+// LookupRecords looks up the records of name on all upstreams and returns
+// the first answer. Upstreams not implementing RecordResolver fail with
+// ErrUnsupportedRecordType and lose the race.
+func (r *RaceResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	return r.race(ctx, func(ctx context.Context, res DNSResolver) ([]string, time.Duration, error) {
+		return lookupRecordsTTL(ctx, res, rtype, name)
+	})
+}
+
+// Latencies returns the moving average of the latency of each upstream in
+// Resolvers, zero for upstreams which never answered first or when
+// RecordLatency is disabled.
+func (r *RaceResolver) Latencies() []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latencies := make([]time.Duration, len(r.Resolvers))
+	copy(latencies, r.latencies)
+	return latencies
+}
+
+func (r *RaceResolver) race(ctx context.Context, fn func(ctx context.Context, res DNSResolver) ([]string, time.Duration, error)) ([]string, time.Duration, error) {
+	if len(r.Resolvers) == 0 {
+		return nil, 0, errNoUpstream
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	// Buffered so that losers never block once the winner was picked.
+	answers := make(chan raceAnswer, len(r.Resolvers))
+	for i, res := range r.Resolvers {
+		go func(i int, res DNSResolver) {
+			ctx, rec := nestUpstream(ctx)
+			rrs, ttl, err := fn(ctx, res)
+			answers <- raceAnswer{index: i, rrs: rrs, ttl: ttl, err: err, upstream: rec}
+		}(i, res)
+	}
+
+	var err error
+	for range r.Resolvers {
+		a := <-answers
+		if a.err == nil || isNotFound(a.err) {
+			r.recordLatency(a.index, time.Since(start))
+			reportUpstream(ctx, r.Resolvers[a.index], a.upstream)
+			return a.rrs, a.ttl, a.err
+		}
+		err = a.err
+	}
+	return nil, 0, err
+}
+
+func (r *RaceResolver) recordLatency(i int, d time.Duration) {
+	if !r.RecordLatency {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) != len(r.Resolvers) {
+		r.latencies = make([]time.Duration, len(r.Resolvers))
+	}
+	if r.latencies[i] == 0 {
+		r.latencies[i] = d
+		return
+	}
+	r.latencies[i] += time.Duration(raceLatencyWeight * float64(d-r.latencies[i]))
+}
@@ -0,0 +1,72 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestRaceResolver(t *testing.T) {
+	r := &RaceResolver{
+		Resolvers:     []DNSResolver{&slowResolver{delay: time.Second}, &fakeResolver{}, BadResolver{}},
+		RecordLatency: true,
+	}
+
+	start := time.Now()
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("lookup took %v, want the fastest answer", d)
+	}
+	if len(addrs) != 1 || addrs[0] != "216.58.192.238" {
+		t.Errorf("addrs = %v, want [216.58.192.238]", addrs)
+	}
+	if latencies := r.Latencies(); latencies[2] == 0 || latencies[0] != 0 || latencies[1] != 0 {
+		t.Errorf("Latencies() = %v, want only the winner recorded", latencies)
+	}
+}
+
+func TestRaceResolver_AllFail(t *testing.T) {
+	r := &RaceResolver{Resolvers: []DNSResolver{&fakeResolver{}, &fakeResolver{}}}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err == nil {
+		t.Error("LookupHost succeeded, want error")
+	}
+}
+
+func TestRaceResolver_LookupRecords(t *testing.T) {
+	upstream := &recordsResolver{rrs: map[RecordType][]string{TypeMX: {"10 mx.example.com."}}}
+	r := &RaceResolver{Resolvers: []DNSResolver{BadResolver{}, upstream}}
+	var _ RecordResolver = r
+
+	rrs, _, err := r.LookupRecords(context.Background(), TypeMX, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != 1 || rrs[0] != "10 mx.example.com." {
+		t.Errorf("rrs = %v, want [10 mx.example.com.]", rrs)
+	}
+	if _, _, err := r.LookupRecords(context.Background(), TypeTXT, "example.com"); err != ErrUnsupportedRecordType {
+		t.Errorf("unsupported type err = %v, want %v", err, ErrUnsupportedRecordType)
+	}
+}
+
+func TestResolver_RaceUpstreamWithoutTTL(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1")
+	r := &Resolver{Resolver: &RaceResolver{Resolvers: []DNSResolver{upstream, &fakeResolver{}}}}
+	for i := 0; i < 5; i++ {
+		if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := upstream.Calls("example.com"); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+	if hits := r.Stats().Hits; hits != 4 {
+		t.Errorf("Hits = %d, want 4", hits)
+	}
+}
@@ -0,0 +1,92 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for upstream lookups rejected because they
+// exceed Resolver.RateLimit, see RateLimitMaxWait and RateLimitFailFast.
+var ErrRateLimited = errors.New("dnscache: upstream lookup rate limit exceeded")
+
+// defaultRateLimitMaxWait is the default of Resolver.RateLimitMaxWait.
+const defaultRateLimitMaxWait = 5 * time.Second
+
+// tokenBucket implements the upstream lookup rate limit. Tokens may go
+// negative, in which case the deficit is the queue of waiting lookups.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from b at now, refilled at rate tokens per second
+// up to burst, and returns how long the caller must wait before using it.
+// No token is taken and ok is false if that would be longer than maxWait.
+func (b *tokenBucket) reserve(now time.Time, rate float64, burst int, maxWait time.Duration) (wait time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	if wait > maxWait {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// refund gives back a token taken by reserve but not used, up to burst.
+func (b *tokenBucket) refund(burst int) {
+	b.mu.Lock()
+	b.tokens = math.Min(float64(burst), b.tokens+1)
+	b.mu.Unlock()
+}
+
+// waitRateLimit blocks until an upstream lookup is allowed by RateLimit or
+// ctx, the context of the lookup, is done. It returns ErrRateLimited if
+// the lookup would wait longer than RateLimitMaxWait, or at all if
+// RateLimitFailFast is set.
+func (r *Resolver) waitRateLimit(ctx context.Context) error {
+	if r.RateLimit <= 0 {
+		return nil
+	}
+	burst := r.RateBurst
+	if burst <= 0 {
+		burst = int(math.Ceil(r.RateLimit))
+	}
+	maxWait := r.RateLimitMaxWait
+	if maxWait <= 0 {
+		maxWait = defaultRateLimitMaxWait
+	}
+	if r.RateLimitFailFast {
+		maxWait = 0
+	}
+	wait, ok := r.limiter.reserve(r.now(), r.RateLimit, burst, maxWait)
+	if !ok {
+		r.stats.rateLimited.Add(1)
+		return ErrRateLimited
+	}
+	if wait > 0 {
+		r.stats.rateLimitWaits.Add(1)
+		r.sleep(ctx, wait)
+		if err := ctx.Err(); err != nil {
+			r.limiter.refund(burst)
+			return err
+		}
+	}
+	return nil
+}
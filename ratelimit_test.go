@@ -0,0 +1,94 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolver_RateLimitFailFast(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, RateLimit: 1, RateBurst: 2, RateLimitFailFast: true}
+
+	hosts := benchmarkHosts(3)
+	for _, host := range hosts[:2] {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.LookupHost(context.Background(), hosts[2]); err != ErrRateLimited {
+		t.Errorf("err = %v, want %v", err, ErrRateLimited)
+	}
+	// Cached names are still served.
+	if _, err := r.LookupHost(context.Background(), hosts[0]); err != nil {
+		t.Errorf("cached lookup failed: %v", err)
+	}
+	if n := r.Stats().RateLimited; n != 1 {
+		t.Errorf("RateLimited = %d, want 1", n)
+	}
+}
+
+func TestResolver_RateLimitWait(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, RateLimit: 20, RateBurst: 1}
+
+	start := time.Now()
+	for _, host := range benchmarkHosts(3) {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("3 lookups at 20/s took %v, want at least 100ms", d)
+	}
+	if n := r.Stats().RateLimitWaits; n != 2 {
+		t.Errorf("RateLimitWaits = %d, want 2", n)
+	}
+}
+
+func TestResolver_RateLimitMaxWait(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, RateLimit: 1, RateBurst: 1, RateLimitMaxWait: 100 * time.Millisecond}
+	hosts := benchmarkHosts(2)
+	if _, err := r.LookupHost(context.Background(), hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := r.LookupHost(context.Background(), hosts[1]); err != ErrRateLimited {
+		t.Errorf("err = %v, want %v", err, ErrRateLimited)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("lookup over the maximum wait took %v, want no wait", d)
+	}
+}
+
+func TestResolver_RateLimitCancelled(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, RateLimit: 1, RateBurst: 1, RateLimitMaxWait: time.Hour, PropagateContext: true}
+	hosts := benchmarkHosts(2)
+	if _, err := r.LookupHost(context.Background(), hosts[0]); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.LookupHost(ctx, hosts[1]); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The abandoned lookup stops waiting and gives its token back.
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.flightsMu.Lock()
+		running := r.running
+		r.flightsMu.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rate limited lookup still waiting after its caller gave up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r.limiter.mu.Lock()
+	tokens := r.limiter.tokens
+	r.limiter.mu.Unlock()
+	if tokens < -0.5 {
+		t.Errorf("tokens = %.2f, want the reservation refunded", tokens)
+	}
+}
@@ -0,0 +1,125 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RawResolver is a lightweight DNSResolver speaking the DNS wire protocol
+// directly to a single nameserver. It supports A, AAAA, PTR, CNAME, MX, SRV
+// and TXT lookups and implements TTLResolver, so the cache knows how long
+// each answer is valid.
+// Queries are sent over UDP and retried over TCP when the answer is
+// truncated.
+type RawResolver struct {
+	// Server is the address of the nameserver in host:port form. If the
+	// port is omitted, 53 is used.
+	Server string
+
+	// Timeout bounds a single exchange with the server. If zero, only the
+	// lookup context deadline applies.
+	Timeout time.Duration
+
+	// Dialer is used to connect to the server. If nil, a zero net.Dialer is
+	// used.
+	Dialer *net.Dialer
+
+	// RequireDNSSEC makes lookups request DNSSEC validation from the server
+	// and fail with ErrUnauthenticatedAnswer when it does not vouch for the
+	// answer with the AD bit. The records are not validated locally, so the
+	// server must be a validating resolver reached over a path trusted not
+	// to tamper with the bit, e.g. on the host itself; prefer DoHResolver or
+	// DoTResolver otherwise.
+	RequireDNSSEC bool
+}
+
+// String returns Server, which names the resolver in EntryInfo.Source and
+// Upstream.
+func (r *RawResolver) String() string {
+	return r.Server
+}
+
+// LookupHost looks up the A and AAAA records of host.
+func (r *RawResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = r.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up the PTR records of addr.
+func (r *RawResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = r.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the smallest TTL of the
+// answers.
+func (r *RawResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	return lookupHostWire(ctx, r.exchange, host, wireOptions{dnssec: r.RequireDNSSEC})
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the smallest TTL of the
+// answers.
+func (r *RawResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	return lookupAddrWire(ctx, r.exchange, addr, wireOptions{dnssec: r.RequireDNSSEC})
+}
+
+// LookupRecords looks up the CNAME, MX, SRV or TXT records of name.
+func (r *RawResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	return lookupRecordsWire(ctx, r.exchange, rtype, name, wireOptions{dnssec: r.RequireDNSSEC})
+}
+
+func (r *RawResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	server := r.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	resp, err := r.exchangeConn(ctx, "udp", server, query)
+	if err != nil {
+		return nil, err
+	}
+	var p dnsmessage.Parser
+	if h, err := p.Start(resp); err == nil && h.Truncated {
+		return r.exchangeConn(ctx, "tcp", server, query)
+	}
+	return resp, nil
+}
+
+func (r *RawResolver) exchangeConn(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	d := r.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer bindConn(ctx, conn)()
+
+	if network == "tcp" {
+		if err = writeStreamMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readStreamMessage(conn)
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, ednsUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,175 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testRawHandler(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+	switch q.Name.String() {
+	case "example.test.":
+		switch q.Type {
+		case dnsmessage.TypeA:
+			return []dnsmessage.Resource{
+				testResource("example.test.", 300, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}),
+				testResource("example.test.", 60, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}),
+			}, dnsmessage.RCodeSuccess
+		case dnsmessage.TypeAAAA:
+			return []dnsmessage.Resource{
+				testResource("example.test.", 120, &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}),
+			}, dnsmessage.RCodeSuccess
+		}
+	case "1.2.0.192.in-addr.arpa.":
+		return []dnsmessage.Resource{
+			testResource("1.2.0.192.in-addr.arpa.", 30, &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("example.test.")}),
+		}, dnsmessage.RCodeSuccess
+	}
+	return nil, dnsmessage.RCodeNameError
+}
+
+func TestRawResolver_LookupHostTTL(t *testing.T) {
+	r := &RawResolver{Server: startTestDNSServer(t, testRawHandler), Timeout: time.Second}
+
+	addrs, ttl, err := r.LookupHostTTL(context.Background(), "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}
+	if len(addrs) != len(want) {
+		t.Fatalf("addrs = %v, want %v", addrs, want)
+	}
+	for i := range want {
+		if addrs[i] != want[i] {
+			t.Errorf("addrs[%d] = %s, want %s", i, addrs[i], want[i])
+		}
+	}
+	if ttl != 60*time.Second {
+		t.Errorf("ttl = %v, want 1m0s", ttl)
+	}
+}
+
+func TestRawResolver_LookupAddrTTL(t *testing.T) {
+	r := &RawResolver{Server: startTestDNSServer(t, testRawHandler), Timeout: time.Second}
+
+	names, ttl, err := r.LookupAddrTTL(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "example.test." {
+		t.Errorf("names = %v, want [example.test.]", names)
+	}
+	if ttl != 30*time.Second {
+		t.Errorf("ttl = %v, want 30s", ttl)
+	}
+}
+
+func TestRawResolver_NotFound(t *testing.T) {
+	r := &RawResolver{Server: startTestDNSServer(t, testRawHandler), Timeout: time.Second}
+
+	_, err := r.LookupHost(context.Background(), "missing.test")
+	dnsErr, ok := err.(*net.DNSError)
+	if !ok || !dnsErr.IsNotFound {
+		t.Errorf("err = %v, want not found DNSError", err)
+	}
+}
+
+func TestResolver_TTLResolverSetsExpiry(t *testing.T) {
+	r := &Resolver{Resolver: &RawResolver{Server: startTestDNSServer(t, testRawHandler), Timeout: time.Second}}
+
+	if _, err := r.LookupHost(context.Background(), "example.test"); err != nil {
+		t.Fatal(err)
+	}
+	e := r.entry("hexample.test")
+	if e == nil {
+		t.Fatal("entry not cached")
+	}
+	if d := time.Until(e.expires); d <= 0 || d > time.Minute {
+		t.Errorf("entry expires in %v, want within 1m0s", d)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1":   "1.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		got, err := reverseName(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("reverseName(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+// ttlResolver answers every lookup like BadResolver with a fixed TTL.
+type ttlResolver struct {
+	BadResolver
+	ttl time.Duration
+}
+
+func (r ttlResolver) LookupHostTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	addrs, err := r.LookupHost(ctx, host)
+	return addrs, r.ttl, err
+}
+
+func (r ttlResolver) LookupAddrTTL(ctx context.Context, addr string) ([]string, time.Duration, error) {
+	names, err := r.LookupAddr(ctx, addr)
+	return names, r.ttl, err
+}
+
+func TestResolver_ClampTTL(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{0, 5 * time.Second},
+		{time.Minute, time.Minute},
+		{24 * time.Hour, time.Hour},
+	}
+	for _, tt := range tests {
+		r := &Resolver{Resolver: ttlResolver{ttl: tt.ttl}, MinTTL: 5 * time.Second, MaxTTL: time.Hour}
+		if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+		e := r.entry("hexample.com")
+		if d := time.Until(e.expires); d > tt.want || d < tt.want-time.Second {
+			t.Errorf("TTL %v: entry expires in %v, want %v", tt.ttl, d, tt.want)
+		}
+	}
+}
+
+func TestQueryWire_DNSSEC(t *testing.T) {
+	for _, authenticated := range []bool{false, true} {
+		exchange := func(ctx context.Context, query []byte) ([]byte, error) {
+			var msg dnsmessage.Message
+			if err := msg.Unpack(query); err != nil {
+				return nil, err
+			}
+			if !msg.AuthenticData || len(msg.Additionals) != 1 || !msg.Additionals[0].Header.DNSSECAllowed() {
+				t.Errorf("query does not request DNSSEC: %+v", msg.Header)
+			}
+			resp, err := answerQuery(query, testRawHandler)
+			if err != nil || !authenticated {
+				return resp, err
+			}
+			if err = msg.Unpack(resp); err != nil {
+				return nil, err
+			}
+			msg.AuthenticData = true
+			return msg.Pack()
+		}
+		addrs, _, err := lookupHostWire(context.Background(), exchange, "example.test", wireOptions{dnssec: true})
+		if authenticated && (err != nil || len(addrs) != 3) {
+			t.Errorf("authenticated answer: addrs = %v, err = %v, want 3 addresses", addrs, err)
+		}
+		if !authenticated && err != ErrUnauthenticatedAnswer {
+			t.Errorf("unauthenticated answer: err = %v, want %v", err, ErrUnauthenticatedAnswer)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecordType is the type of the records of a lookup, keeping the cache
+// entries of different lookups of a name apart, see LookupRecords.
+type RecordType byte
+
+// Record types. Their values are the first byte of the singleflight keys of
+// their lookups.
+const (
+	// TypeHost are the addresses of both families, as returned by
+	// LookupHost.
+	TypeHost RecordType = 'h'
+
+	// TypeA and TypeAAAA are the addresses of a single family, as returned
+	// by LookupNetHost.
+	TypeA    RecordType = '4'
+	TypeAAAA RecordType = '6'
+
+	// TypePTR are the names of an address, as returned by LookupAddr.
+	TypePTR RecordType = 'r'
+
+	// TypeCNAME is the canonical name of a name, with a trailing dot.
+	TypeCNAME RecordType = 'c'
+
+	// TypeMX are the mail exchangers of a domain, as "pref host", e.g.
+	// "10 mx.example.com.".
+	TypeMX RecordType = 'm'
+
+	// TypeSRV are the service records of a name such as
+	// "_sip._tcp.example.com", as "priority weight port target", e.g.
+	// "10 5 5060 sip.example.com.".
+	TypeSRV RecordType = 's'
+
+	// TypeTXT are the text records of a name, each with its strings
+	// concatenated.
+	TypeTXT RecordType = 't'
+)
+
+// ErrUnsupportedRecordType is returned by LookupRecords for a record type
+// which neither the cache nor the upstream resolver supports.
+var ErrUnsupportedRecordType = errors.New("dnscache: unsupported record type")
+
+// RecordResolver is an optional interface a DNSResolver can implement to
+// resolve the record types besides addresses and reverse names: TypeCNAME,
+// TypeMX, TypeSRV and TypeTXT, formatted as documented for each type. The
+// returned ttl is the smallest TTL of the records, or zero if unknown. It
+// should return ErrUnsupportedRecordType for the types it cannot resolve.
+type RecordResolver interface {
+	LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error)
+}
+
+// String returns the name of t, e.g. "AAAA".
+func (t RecordType) String() string {
+	switch t {
+	case TypeHost:
+		return "HOST"
+	case TypeA:
+		return "A"
+	case TypeAAAA:
+		return "AAAA"
+	case TypePTR:
+		return "PTR"
+	case TypeCNAME:
+		return "CNAME"
+	case TypeMX:
+		return "MX"
+	case TypeSRV:
+		return "SRV"
+	case TypeTXT:
+		return "TXT"
+	}
+	return "RecordType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// isHostType reports whether t is the type of a lookup of addresses.
+func isHostType(t RecordType) bool {
+	return t == TypeHost || t == TypeA || t == TypeAAAA
+}
+
+// isRecordType reports whether t is resolved through RecordResolver.
+func isRecordType(t RecordType) bool {
+	return t == TypeCNAME || t == TypeMX || t == TypeSRV || t == TypeTXT
+}
+
+// LookupRecords looks up the records of type rtype of name, sharing the
+// cache, refreshes and in-flight lookups of the other lookup methods:
+// TypeHost is LookupHost, TypeA and TypeAAAA are LookupNetHost and
+// TypePTR is LookupAddr of the address name. The other types are resolved
+// with the RecordResolver of the upstream resolver, and fail with
+// ErrUnsupportedRecordType if it does not implement it.
+func (r *Resolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, err error) {
+	switch {
+	case rtype == TypeHost:
+		return r.LookupHost(ctx, name)
+	case rtype == TypeA:
+		return r.LookupNetHost(ctx, "ip4", name)
+	case rtype == TypeAAAA:
+		return r.LookupNetHost(ctx, "ip6", name)
+	case rtype == TypePTR:
+		return r.LookupAddr(ctx, name)
+	case !isRecordType(rtype):
+		return nil, ErrUnsupportedRecordType
+	}
+	r.once.Do(r.init)
+	if name, err = toASCII(name); err != nil {
+		return nil, err
+	}
+	return r.lookup(ctx, cacheKey{rtype: rtype, name: name}, 0, nil)
+}
+
+// lookupRecordsTTL looks up the records of type rtype of name through
+// resolver, if it implements RecordResolver. Like with lookupHostTTL, the
+// TTL is NoTTL unless resolver implements TTLResolver.
+func lookupRecordsTTL(ctx context.Context, resolver DNSResolver, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	rr, ok := resolver.(RecordResolver)
+	if !ok {
+		return nil, 0, ErrUnsupportedRecordType
+	}
+	rrs, ttl, err = rr.LookupRecords(ctx, rtype, name)
+	if _, ok := resolver.(TTLResolver); !ok {
+		ttl = NoTTL
+	}
+	return rrs, ttl, err
+}
+
+// canonicalRecords is canonicalNames for the record types holding names.
+// Text records are case sensitive and kept as is.
+func canonicalRecords(rtype RecordType, rrs []string) []string {
+	if rtype == TypeTXT {
+		return rrs
+	}
+	return canonicalNames(rrs)
+}
+
+// LookupRecords looks up the records of name with the net.Resolver of d.
+func (d *defaultResolverWithTrace) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	switch rtype {
+	case TypeCNAME:
+		var cname string
+		if cname, err = d.netResolver().LookupCNAME(ctx, name); err == nil {
+			rrs = []string{cname}
+		}
+	case TypeMX:
+		var mxs []*net.MX
+		mxs, err = d.netResolver().LookupMX(ctx, name)
+		for _, mx := range mxs {
+			rrs = append(rrs, formatMX(mx.Pref, mx.Host))
+		}
+	case TypeSRV:
+		var srvs []*net.SRV
+		_, srvs, err = d.netResolver().LookupSRV(ctx, "", "", name)
+		for _, srv := range srvs {
+			rrs = append(rrs, formatSRV(srv.Priority, srv.Weight, srv.Port, srv.Target))
+		}
+	case TypeTXT:
+		rrs, err = d.netResolver().LookupTXT(ctx, name)
+	default:
+		err = ErrUnsupportedRecordType
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return rrs, 0, nil
+}
+
+func formatMX(pref uint16, host string) string {
+	return strconv.Itoa(int(pref)) + " " + host
+}
+
+func formatSRV(priority, weight, port uint16, target string) string {
+	return strings.Join([]string{
+		strconv.Itoa(int(priority)),
+		strconv.Itoa(int(weight)),
+		strconv.Itoa(int(port)),
+		target,
+	}, " ")
+}
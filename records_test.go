@@ -0,0 +1,172 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// recordsResolver answers LookupRecords with the records of rrs for their
+// type, counting the lookups.
+type recordsResolver struct {
+	BadResolver
+	rrs   map[RecordType][]string
+	calls atomic.Int32
+}
+
+func (r *recordsResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) ([]string, time.Duration, error) {
+	r.calls.Add(1)
+	rrs, ok := r.rrs[rtype]
+	if !ok {
+		return nil, 0, ErrUnsupportedRecordType
+	}
+	return rrs, 0, nil
+}
+
+func TestResolver_LookupRecords(t *testing.T) {
+	upstream := &recordsResolver{rrs: map[RecordType][]string{
+		TypeMX:  {"10 mx1.example.com.", "20 mx2.example.com."},
+		TypeTXT: {"v=spf1 -all"},
+	}}
+	r := &Resolver{Resolver: upstream}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		rrs, err := r.LookupRecords(ctx, TypeMX, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameAddrs(rrs, upstream.rrs[TypeMX]) {
+			t.Errorf("MX records = %v, want %v", rrs, upstream.rrs[TypeMX])
+		}
+	}
+	if n := upstream.calls.Load(); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+
+	// The records of each type of a name are cached apart.
+	rrs, err := r.LookupRecords(ctx, TypeTXT, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != 1 || rrs[0] != "v=spf1 -all" {
+		t.Errorf("TXT records = %v, want [v=spf1 -all]", rrs)
+	}
+	if n := upstream.calls.Load(); n != 2 {
+		t.Errorf("upstream called %d times, want 2", n)
+	}
+
+	// Address types share the entries of LookupHost.
+	if _, err = r.LookupRecords(ctx, TypeHost, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Peek("example.com"); !ok {
+		t.Error("TypeHost lookup did not cache a LookupHost entry")
+	}
+
+	types := map[RecordType]bool{}
+	for _, info := range r.Entries() {
+		types[info.Type] = true
+	}
+	for _, rtype := range []RecordType{TypeHost, TypeMX, TypeTXT} {
+		if !types[rtype] {
+			t.Errorf("Entries lacks the %v entry", rtype)
+		}
+	}
+
+	r.Refresh()
+	if n := upstream.calls.Load(); n != 4 {
+		t.Errorf("upstream called %d times after Refresh, want 4", n)
+	}
+}
+
+func TestResolver_LookupRecordsUnsupported(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}}
+	if _, err := r.LookupRecords(context.Background(), TypeSRV, "_sip._tcp.example.com"); !errors.Is(err, ErrUnsupportedRecordType) {
+		t.Errorf("upstream without RecordResolver: err = %v, want ErrUnsupportedRecordType", err)
+	}
+	if _, err := r.LookupRecords(context.Background(), RecordType('x'), "example.com"); !errors.Is(err, ErrUnsupportedRecordType) {
+		t.Errorf("unknown type: err = %v, want ErrUnsupportedRecordType", err)
+	}
+}
+
+func TestResolver_LookupRecordsSnapshot(t *testing.T) {
+	upstream := &recordsResolver{rrs: map[RecordType][]string{
+		TypeSRV: {"10 5 5060 sip.example.com."},
+	}}
+	r := &Resolver{Resolver: upstream}
+	if _, err := r.LookupRecords(context.Background(), TypeSRV, "_sip._tcp.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &Resolver{Resolver: BadResolver{}}
+	if err = restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	rrs, err := restored.LookupRecords(context.Background(), TypeSRV, "_sip._tcp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != 1 || rrs[0] != "10 5 5060 sip.example.com." {
+		t.Errorf("restored SRV records = %v", rrs)
+	}
+}
+
+func TestRawResolver_LookupRecords(t *testing.T) {
+	server := startTestDNSServer(t, func(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+		name := q.Name.String()
+		switch q.Type {
+		case dnsmessage.TypeCNAME:
+			return []dnsmessage.Resource{
+				testResource(name, 300, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("target.test.")}),
+			}, dnsmessage.RCodeSuccess
+		case dnsmessage.TypeMX:
+			return []dnsmessage.Resource{
+				testResource(name, 300, &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx.test.")}),
+			}, dnsmessage.RCodeSuccess
+		case dnsmessage.TypeSRV:
+			return []dnsmessage.Resource{
+				testResource(name, 300, &dnsmessage.SRVResource{Priority: 1, Weight: 2, Port: 3, Target: dnsmessage.MustNewName("srv.test.")}),
+			}, dnsmessage.RCodeSuccess
+		case dnsmessage.TypeTXT:
+			return []dnsmessage.Resource{
+				testResource(name, 60, &dnsmessage.TXTResource{TXT: []string{"hello ", "world"}}),
+			}, dnsmessage.RCodeSuccess
+		}
+		return nil, dnsmessage.RCodeNameError
+	})
+	r := &RawResolver{Server: server, Timeout: time.Second}
+
+	for _, tc := range []struct {
+		rtype RecordType
+		want  string
+	}{
+		{TypeCNAME, "target.test."},
+		{TypeMX, "10 mx.test."},
+		{TypeSRV, "1 2 3 srv.test."},
+		{TypeTXT, "hello world"},
+	} {
+		rrs, _, err := r.LookupRecords(context.Background(), tc.rtype, "example.test")
+		if err != nil {
+			t.Errorf("%v: %v", tc.rtype, err)
+			continue
+		}
+		if len(rrs) != 1 || rrs[0] != tc.want {
+			t.Errorf("%v records = %q, want [%q]", tc.rtype, rrs, tc.want)
+		}
+	}
+	if _, ttl, _ := r.LookupRecords(context.Background(), TypeTXT, "example.test"); ttl != time.Minute {
+		t.Errorf("TXT ttl = %v, want 1m0s", ttl)
+	}
+	if _, _, err := r.LookupRecords(context.Background(), TypePTR, "example.test"); !errors.Is(err, ErrUnsupportedRecordType) {
+		t.Errorf("PTR: err = %v, want ErrUnsupportedRecordType", err)
+	}
+}
@@ -0,0 +1,120 @@
+package dnscache
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshAction is what to do with a cache entry whose refresh failed, as
+// decided by Resolver.RefreshErrorPolicy.
+type RefreshAction int
+
+const (
+	// KeepStale keeps serving the cached records until the next refresh.
+	KeepStale RefreshAction = iota
+
+	// Evict deletes the entry, so that the next lookup goes upstream.
+	Evict
+
+	// Retry refreshes the entry again with the backoff configured by the
+	// Resolver retry settings, at least once, and keeps serving the cached
+	// records if all attempts fail.
+	Retry
+)
+
+// DefaultRefreshErrorPolicy evicts entries whose name no longer exists
+// according to an authoritative answer, and keeps serving the cached
+// records after any other error, e.g. a timeout.
+func DefaultRefreshErrorPolicy(err error) RefreshAction {
+	if isNotFound(err) {
+		return Evict
+	}
+	return KeepStale
+}
+
+// RefreshReport is the outcome of a refresh, as returned by
+// RefreshWithReport. Entries are reported by their name, the address of
+// reverse entries, followed by their record type unless they are entries
+// of LookupHost or LookupAddr, e.g. "example.com AAAA".
+type RefreshReport struct {
+	// Refreshed are the entries successfully updated from upstream.
+	Refreshed []string
+
+	// Evicted are the entries deleted, either as not used since the
+	// previous refresh or after their refresh failed, as decided by
+	// RefreshErrorPolicy.
+	Evicted []string
+
+	// Failed maps the entries whose refresh failed to the error of its
+	// last attempt. Those not evicted keep serving their cached records.
+	Failed map[string]error
+
+	// Duration is how long the refresh took.
+	Duration time.Duration
+}
+
+// RefreshWithReport is like Refresh but reports the outcome, e.g. to log
+// or alert on the entries failing to refresh.
+func (r *Resolver) RefreshWithReport() RefreshReport {
+	return r.refreshRecords(context.Background())
+}
+
+// reportName returns the name of the entry of k in a RefreshReport.
+func (k cacheKey) reportName() string {
+	if k.rtype == TypeHost || k.rtype == TypePTR {
+		return k.name
+	}
+	return k.name + " " + k.rtype.String()
+}
+
+// refreshKey updates the entry of key from upstream and applies
+// RefreshErrorPolicy if that fails. It reports whether the entry was
+// evicted and returns the error of the last attempt, if any.
+func (r *Resolver) refreshKey(ctx context.Context, key cacheKey) (evicted bool, err error) {
+	_, err = r.update(ctx, key, false, nil)
+	if err == nil || ctx.Err() != nil || r.cachesNegative(key, err) {
+		return false, err
+	}
+	r.stats.refreshErrors.Add(1)
+
+	policy := r.RefreshErrorPolicy
+	if policy == nil {
+		policy = DefaultRefreshErrorPolicy
+	}
+	switch policy(err) {
+	case Evict:
+		if !r.isPinned(key) && r.remove(key) {
+			r.stats.refreshEvictions.Add(1)
+			evicted = true
+		}
+	case Retry:
+		delay := r.RetryBaseDelay
+		if delay <= 0 {
+			delay = defaultRetryBaseDelay
+		}
+		for attempt := 0; attempt == 0 || attempt < r.RetryAttempts; attempt++ {
+			r.sleep(ctx, jitter(delay, r.RetryJitter))
+			if _, err = r.update(ctx, key, false, nil); err == nil || ctx.Err() != nil {
+				return false, err
+			}
+			delay *= 2
+		}
+	}
+	return evicted, err
+}
+
+// ForceRefresh resolves host upstream right away and replaces its cache
+// entry with the answer, e.g. after connections to the cached addresses
+// failed. A lookup of host already in flight is not joined, as it may have
+// started before the failure, and its answer is not cached. On error the
+// cached entry is left as is.
+func (r *Resolver) ForceRefresh(ctx context.Context, host string) (addrs []string, err error) {
+	r.once.Do(r.init)
+	if host, err = toASCII(host); err != nil {
+		return nil, err
+	}
+	key := cacheKey{rtype: TypeHost, name: host}
+	r.invalidateFlights(key)
+	addrs, err = r.update(ctx, key, true, nil)
+	return r.results(addrs), err
+}
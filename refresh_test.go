@@ -0,0 +1,343 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+// switchResolver delegates to Resolver, which tests swap between lookups.
+type switchResolver struct {
+	Resolver DNSResolver
+}
+
+func (r *switchResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.Resolver.LookupHost(ctx, host)
+}
+
+func (r *switchResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.Resolver.LookupAddr(ctx, addr)
+}
+
+func TestResolver_RefreshErrorPolicy(t *testing.T) {
+	notFound := notFoundResolver{missing: map[string]bool{"example.com": true}}
+	tests := []struct {
+		name      string
+		policy    func(error) RefreshAction
+		upstream  DNSResolver
+		wantEntry bool
+	}{
+		{"default not found", nil, notFound, false},
+		{"default temporary", nil, BadResolver{choke: true}, true},
+		{"keep not found", func(error) RefreshAction { return KeepStale }, notFound, true},
+		{"evict any", func(error) RefreshAction { return Evict }, BadResolver{choke: true}, false},
+		{"retry", func(error) RefreshAction { return Retry }, &flakyResolver{failures: 1, err: errors.New("fail")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &switchResolver{Resolver: BadResolver{}}
+			r := &Resolver{
+				Resolver:           upstream,
+				RefreshErrorPolicy: tt.policy,
+				RetryBaseDelay:     time.Millisecond,
+			}
+			if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+				t.Fatal(err)
+			}
+			upstream.Resolver = tt.upstream
+			r.Refresh()
+
+			if got := r.entry("hexample.com") != nil; got != tt.wantEntry {
+				t.Errorf("entry cached = %v, want %v", got, tt.wantEntry)
+			}
+			if f, ok := tt.upstream.(*flakyResolver); ok && f.calls != 2 {
+				t.Errorf("upstream called %d times during refresh, want 2", f.calls)
+			}
+			if n := r.Stats().RefreshErrors; n != 1 {
+				t.Errorf("RefreshErrors = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestResolver_RefreshSpread(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}, RefreshSpread: 100 * time.Millisecond}
+	for _, host := range benchmarkHosts(4) {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	r.Refresh()
+	// The last entry is refreshed within the last quarter of the window.
+	if d := time.Since(start); d < 75*time.Millisecond || d > 200*time.Millisecond {
+		t.Errorf("Refresh took %v, want about 100ms", d)
+	}
+}
+
+func TestResolver_RefreshCtx(t *testing.T) {
+	upstream := &switchResolver{Resolver: BadResolver{}}
+	r := &Resolver{Resolver: upstream}
+	hosts := benchmarkHosts(10)
+	for _, host := range hosts {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upstream.Resolver = &slowResolver{delay: 50 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 75*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	r.RefreshCtx(ctx)
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("RefreshCtx took %v after its context expired", d)
+	}
+	for _, host := range hosts {
+		if r.entry("h"+host) == nil {
+			t.Errorf("entry of %s dropped by an aborted refresh", host)
+		}
+	}
+}
+
+func TestResolver_RefreshConcurrency(t *testing.T) {
+	upstream := &switchResolver{Resolver: BadResolver{}}
+	r := &Resolver{Resolver: upstream, RefreshConcurrency: 5}
+	for _, host := range benchmarkHosts(10) {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slow := &slowResolver{delay: 50 * time.Millisecond}
+	upstream.Resolver = slow
+
+	start := time.Now()
+	r.Refresh()
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("refreshing 10 entries with 5 workers took %v, want about 100ms", d)
+	}
+	if slow.calls != 10 {
+		t.Errorf("upstream called %d times, want 10", slow.calls)
+	}
+}
+
+func TestResolver_ForceRefresh(t *testing.T) {
+	ctx := context.Background()
+	upstream := &switchResolver{Resolver: BadResolver{}}
+	r := &Resolver{Resolver: upstream}
+	if _, err := r.LookupHost(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	upstream.Resolver = &slowResolver{}
+	addrs, err := r.ForceRefresh(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+		t.Errorf("ForceRefresh = %v, want [192.0.2.1]", addrs)
+	}
+	if got, _ := r.Peek("example.com"); len(got) != 1 || got[0] != "192.0.2.1" {
+		t.Errorf("cached addresses = %v, want [192.0.2.1]", got)
+	}
+
+	upstream.Resolver = BadResolver{choke: true}
+	if _, err := r.ForceRefresh(ctx, "example.com"); err == nil {
+		t.Error("ForceRefresh error = nil on upstream failure")
+	}
+	if got, _ := r.Peek("example.com"); len(got) != 1 {
+		t.Errorf("cached addresses = %v after failed refresh, want them kept", got)
+	}
+}
+
+func TestResolver_ForceRefreshInFlight(t *testing.T) {
+	gate := gateResolver{started: make(chan string), release: make(chan struct{})}
+	upstream := &switchResolver{Resolver: gate}
+	r := &Resolver{Resolver: upstream}
+	errs := lookupBehindGate(r, gate, "example.com")
+
+	upstream.Resolver = &slowResolver{}
+	if _, err := r.ForceRefresh(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	close(gate.release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Peek("example.com"); len(got) != 1 || got[0] != "192.0.2.1" {
+		t.Errorf("cached addresses = %v, want [192.0.2.1] from ForceRefresh", got)
+	}
+}
+
+func TestResolver_OnChange(t *testing.T) {
+	type change struct {
+		host     string
+		old, new []string
+	}
+	var changes []change
+	upstream := &switchResolver{Resolver: BadResolver{}}
+	r := &Resolver{
+		Resolver: upstream,
+		OnChange: func(host string, old, new []string) {
+			changes = append(changes, change{host, old, new})
+		},
+	}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	r.Refresh()
+	if len(changes) != 0 {
+		t.Fatalf("OnChange called %d times for unchanged addresses, want 0", len(changes))
+	}
+
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	upstream.Resolver = &slowResolver{}
+	r.Refresh()
+	if len(changes) != 1 {
+		t.Fatalf("OnChange called %d times, want 1", len(changes))
+	}
+	c := changes[0]
+	if c.host != "example.com" || len(c.old) != 1 || c.old[0] != "216.58.192.238" || len(c.new) != 1 || c.new[0] != "192.0.2.1" {
+		t.Errorf("OnChange(%q, %v, %v), want (example.com, [216.58.192.238], [192.0.2.1])", c.host, c.old, c.new)
+	}
+}
+
+func TestResolver_RefreshHotHits(t *testing.T) {
+	ctx := context.Background()
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("hot.example.com", "192.0.2.1")
+	upstream.SetHost("cold.example.com", "192.0.2.2")
+	r := &Resolver{Resolver: upstream, RefreshHotHits: 2, RefreshColdEvery: 3}
+	lookup := func(host string, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := r.LookupHost(ctx, host); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	lookup("hot.example.com", 1)
+	lookup("cold.example.com", 1)
+	upstream.Reset()
+
+	for i := 0; i < 3; i++ {
+		lookup("hot.example.com", 3)
+		lookup("cold.example.com", 1)
+		r.Refresh()
+	}
+	if n := upstream.Calls("hot.example.com"); n != 3 {
+		t.Errorf("hot entry refreshed %d times, want 3", n)
+	}
+	if n := upstream.Calls("cold.example.com"); n != 1 {
+		t.Errorf("cold entry refreshed %d times, want 1", n)
+	}
+	if r.entry("hcold.example.com") == nil {
+		t.Error("cold entry was purged")
+	}
+}
+
+func TestResolver_AdaptiveRefresh(t *testing.T) {
+	ctx := context.Background()
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("hot.example.com", "192.0.2.1")
+	upstream.SetHost("cold.example.com", "192.0.2.2")
+	r := &Resolver{Resolver: upstream, AdaptiveRefresh: true, RefreshHotHits: 3}
+	lookup := func(host string, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := r.LookupHost(ctx, host); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	lookup("hot.example.com", 1)
+	lookup("cold.example.com", 1)
+	upstream.Reset()
+
+	for i := 0; i < 8; i++ {
+		lookup("hot.example.com", 3)
+		lookup("cold.example.com", 1)
+		upstream.SetHost("hot.example.com", fmt.Sprintf("192.0.2.%d", 10+i))
+		r.Refresh()
+	}
+	if n := upstream.Calls("hot.example.com"); n != 8 {
+		t.Errorf("changing hot entry refreshed %d times, want 8", n)
+	}
+	// The stable cold entry is refreshed at once, then after 4 refreshes.
+	if n := upstream.Calls("cold.example.com"); n != 2 {
+		t.Errorf("stable cold entry refreshed %d times, want 2", n)
+	}
+	if r.entry("hcold.example.com") == nil {
+		t.Error("cold entry was purged")
+	}
+}
+
+func TestRefreshPolicy_AdaptiveEvery(t *testing.T) {
+	p := refreshPolicy{hotHits: 3, maxCycles: 8}
+	for _, test := range []struct {
+		name      string
+		unchanged int
+		hits      uint64
+		cycles    int
+		want      int
+	}{
+		{"hot changed", 0, 3, 1, 1},
+		{"cold changed", 0, 0, 1, 1},
+		{"hot unchanged", 2, 6, 2, 2},
+		{"cold unchanged", 2, 1, 2, 8},
+		{"cold stable", 10, 0, 8, 8},
+	} {
+		if got := p.adaptiveEvery(test.unchanged, test.hits, test.cycles); got != test.want {
+			t.Errorf("%s: adaptiveEvery(%d, %d, %d) = %d, want %d", test.name, test.unchanged, test.hits, test.cycles, got, test.want)
+		}
+	}
+}
+
+func TestResolver_RefreshWithReport(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"}
+	for _, host := range hosts {
+		upstream.SetHost(host, "192.0.2.1")
+	}
+	r := &Resolver{Resolver: upstream}
+	ctx := context.Background()
+	for _, host := range hosts {
+		if _, err := r.LookupHost(ctx, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if report := r.RefreshWithReport(); len(report.Refreshed) != len(hosts) || len(report.Failed) != 0 {
+		t.Fatalf("first refresh: %+v, want every host refreshed", report)
+	}
+
+	for _, host := range hosts[:3] {
+		if _, err := r.LookupHost(ctx, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upstream.SetHostError("b.example.com", dnscachetest.Temporary("b.example.com"))
+	upstream.SetHostError("c.example.com", dnscachetest.NotFound("c.example.com"))
+	report := r.RefreshWithReport()
+
+	if len(report.Refreshed) != 1 || report.Refreshed[0] != "a.example.com" {
+		t.Errorf("Refreshed = %v, want [a.example.com]", report.Refreshed)
+	}
+	if len(report.Failed) != 2 || report.Failed["b.example.com"] == nil || !isNotFound(report.Failed["c.example.com"]) {
+		t.Errorf("Failed = %v, want b.example.com and c.example.com not found", report.Failed)
+	}
+	evicted := map[string]bool{}
+	for _, name := range report.Evicted {
+		evicted[name] = true
+	}
+	if len(report.Evicted) != 2 || !evicted["c.example.com"] || !evicted["d.example.com"] {
+		t.Errorf("Evicted = %v, want c.example.com and d.example.com", report.Evicted)
+	}
+	if _, ok := r.Peek("b.example.com"); !ok {
+		t.Error("entry failing to refresh temporarily was evicted")
+	}
+}
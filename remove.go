@@ -0,0 +1,63 @@
+package dnscache
+
+// Remove deletes the cached addresses of host, of every address family and
+// client subnet, so that the next LookupHost queries the upstream resolver.
+// A lookup of host already in flight is forgotten, so that it cannot answer
+// lookups started after Remove, and its answer is not cached. The removal
+// is published to Invalidator.
+func (r *Resolver) Remove(host string) {
+	r.once.Do(r.init)
+	host = asciiName(host)
+	r.removeHost(host)
+	r.publish(HostRemoved, host, nil)
+}
+
+// removeHost deletes the entries of host and forgets their lookups.
+func (r *Resolver) removeHost(host string) {
+	for _, rtype := range [...]RecordType{TypeHost, TypeA, TypeAAAA} {
+		for _, key := range r.keysOf(rtype, host) {
+			r.forgetKey(key)
+		}
+	}
+}
+
+// RemoveAddr deletes the cached names of addr, so that the next LookupAddr
+// queries the upstream resolver. Like with Remove, a reverse lookup of addr
+// already in flight is forgotten.
+func (r *Resolver) RemoveAddr(addr string) {
+	r.once.Do(r.init)
+	r.forgetKey(cacheKey{rtype: TypePTR, name: addr})
+}
+
+// forgetKey deletes the entry of key and forgets its in-flight lookups,
+// which then return their answer without caching it.
+func (r *Resolver) forgetKey(key cacheKey) {
+	r.invalidateFlights(key)
+	r.remove(key)
+}
+
+// Flush deletes every cached entry, so that all subsequent lookups query
+// the upstream resolver, e.g. after a large DNS change. Lookups in flight
+// are forgotten and their answers, which may predate the Flush, are not
+// cached. Static entries are kept.
+func (r *Resolver) Flush() {
+	r.once.Do(r.init)
+	r.flightsMu.Lock()
+	for key, flights := range r.flights {
+		for _, f := range flights {
+			f.invalidated.Store(true)
+		}
+		r.group.Forget(key.String())
+	}
+	r.flightsMu.Unlock()
+
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		n := len(s.entries)
+		s.entries = make(map[cacheKey]*cacheEntry)
+		s.bytes = 0
+		s.mu.Unlock()
+		r.size.Add(-int64(n))
+	}
+}
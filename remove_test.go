@@ -0,0 +1,97 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolver_Remove(t *testing.T) {
+	f := &slowResolver{}
+	r := &Resolver{Resolver: f}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Remove("example.com")
+	if r.entry("hexample.com") != nil {
+		t.Error("host entry not removed")
+	}
+	r.RemoveAddr("192.0.2.1")
+	if r.entry("r192.0.2.1") != nil {
+		t.Error("addr entry not removed")
+	}
+	if _, err := r.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 3 {
+		t.Errorf("upstream called %d times, want 3", f.calls)
+	}
+}
+
+func TestResolver_RemoveForgetsInFlightLookup(t *testing.T) {
+	f := &slowResolver{delay: 100 * time.Millisecond}
+	r := &Resolver{Resolver: f}
+	go func() { _, _ = r.LookupHost(context.Background(), "example.com") }()
+	time.Sleep(20 * time.Millisecond)
+
+	r.Remove("example.com")
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 2 {
+		t.Errorf("upstream called %d times, want a new lookup after Remove", f.calls)
+	}
+}
+
+func TestResolver_RemoveDuringLookup(t *testing.T) {
+	upstream := gateResolver{started: make(chan string, 1), release: make(chan struct{})}
+	r := &Resolver{Resolver: upstream}
+	errs := lookupBehindGate(r, upstream, "example.com")
+	r.Remove("example.com")
+	close(upstream.release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if addrs, ok := r.Peek("example.com"); ok {
+		t.Errorf("answer of the lookup started before Remove cached: %v", addrs)
+	}
+}
+
+func TestResolver_Flush(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{}}
+	r.SetStatic("static.example.com", []string{"10.0.0.1"})
+	for _, host := range benchmarkHosts(10) {
+		if _, err := r.LookupHost(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.Flush()
+	if n := r.len(); n != 0 {
+		t.Errorf("cache holds %d entries after Flush, want 0", n)
+	}
+	if n := r.size.Load(); n != 0 {
+		t.Errorf("size = %d after Flush, want 0", n)
+	}
+	if _, found := r.loadStatic("static.example.com"); !found {
+		t.Error("static entry removed by Flush")
+	}
+}
+
+func TestResolver_FlushDuringLookup(t *testing.T) {
+	upstream := gateResolver{started: make(chan string, 1), release: make(chan struct{})}
+	r := &Resolver{Resolver: upstream}
+	errs := lookupBehindGate(r, upstream, "example.com")
+	r.Flush()
+	close(upstream.release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n := r.len(); n != 0 {
+		t.Errorf("cache holds %d entries answered before Flush, want 0", n)
+	}
+}
@@ -0,0 +1,263 @@
+package dnscache
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of resolv.conf(5).
+const (
+	defaultResolvConfNdots   = 1
+	defaultResolvConfTimeout = 5 * time.Second
+)
+
+// resolvConf holds the settings of a resolv.conf file used by the cache.
+type resolvConf struct {
+	servers  []string
+	search   []string
+	ndots    int
+	timeout  time.Duration
+	attempts int
+}
+
+// parseResolvConf parses the nameserver, search, domain and options lines
+// of a resolv.conf file. Other lines and options are ignored.
+func parseResolvConf(rd io.Reader) (*resolvConf, error) {
+	conf := &resolvConf{
+		ndots:    defaultResolvConfNdots,
+		timeout:  defaultResolvConfTimeout,
+		attempts: 2,
+	}
+	s := bufio.NewScanner(rd)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) > 1 && net.ParseIP(fields[1]) != nil {
+				conf.servers = append(conf.servers, net.JoinHostPort(fields[1], "53"))
+			}
+		case "domain":
+			if len(fields) > 1 {
+				conf.search = []string{fields[1]}
+			}
+		case "search":
+			// The last search or domain line wins.
+			conf.search = append([]string(nil), fields[1:]...)
+		case "options":
+			for _, opt := range fields[1:] {
+				name, value, _ := strings.Cut(opt, ":")
+				n, err := strconv.Atoi(value)
+				switch {
+				case err != nil:
+				case name == "ndots":
+					conf.ndots = clampInt(n, 0, 15)
+				case name == "timeout":
+					conf.timeout = time.Duration(clampInt(n, 1, 30)) * time.Second
+				case name == "attempts":
+					conf.attempts = clampInt(n, 1, 5)
+				}
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(conf.servers) == 0 {
+		conf.servers = []string{"127.0.0.1:53"}
+	}
+	for i, domain := range conf.search {
+		conf.search[i] = strings.TrimSuffix(domain, ".")
+	}
+	return conf, nil
+}
+
+func clampInt(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// readResolvConf parses the resolv.conf file at path.
+func readResolvConf(path string) (*resolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseResolvConf(f)
+}
+
+// upstream returns the resolver querying the nameservers of conf in order.
+func (conf *resolvConf) upstream() DNSResolver {
+	servers := make([]DNSResolver, len(conf.servers))
+	for i, server := range conf.servers {
+		servers[i] = &RawResolver{Server: server, Timeout: conf.timeout}
+	}
+	if len(servers) == 1 {
+		return servers[0]
+	}
+	return &FailoverResolver{Resolvers: servers, Timeout: conf.timeout}
+}
+
+// candidates returns the names to try, in order, to resolve host according
+// to the search domains and ndots of conf.
+func (conf *resolvConf) candidates(host string) []string {
+	if strings.HasSuffix(host, ".") || len(conf.search) == 0 || net.ParseIP(host) != nil {
+		return []string{host}
+	}
+	names := make([]string, 0, len(conf.search)+1)
+	dots := strings.Count(host, ".")
+	if dots >= conf.ndots {
+		names = append(names, host)
+	}
+	for _, domain := range conf.search {
+		names = append(names, host+"."+domain)
+	}
+	if dots < conf.ndots {
+		names = append(names, host)
+	}
+	return names
+}
+
+// resolvConfResolver resolves names like the system would with the settings
+// of a resolv.conf file, which can be swapped while lookups run.
+type resolvConfResolver struct {
+	conf     atomic.Pointer[resolvConf]
+	upstream atomic.Pointer[DNSResolver]
+}
+
+func (c *resolvConfResolver) set(conf *resolvConf) {
+	upstream := conf.upstream()
+	c.upstream.Store(&upstream)
+	c.conf.Store(conf)
+}
+
+func (c *resolvConfResolver) String() string {
+	return "resolv.conf"
+}
+
+func (c *resolvConfResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = c.LookupHostTTL(ctx, host)
+	return
+}
+
+func (c *resolvConfResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = c.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL tries the candidate names of host until one exists.
+func (c *resolvConfResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	upstream := *c.upstream.Load()
+	lookupCtx, rec := nestUpstream(ctx)
+	for _, name := range c.conf.Load().candidates(host) {
+		addrs, ttl, err = lookupHostTTL(lookupCtx, upstream, name)
+		if !isNotFound(err) {
+			reportUpstream(ctx, upstream, rec)
+			return addrs, ttl, err
+		}
+	}
+	reportUpstream(ctx, upstream, rec)
+	return nil, 0, errNoSuchHost(host)
+}
+
+func (c *resolvConfResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	upstream := *c.upstream.Load()
+	lookupCtx, rec := nestUpstream(ctx)
+	names, ttl, err = lookupAddrTTL(lookupCtx, upstream, addr)
+	reportUpstream(ctx, upstream, rec)
+	return names, ttl, err
+}
+
+// LookupRecords tries the candidate names of name like LookupHostTTL.
+func (c *resolvConfResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	upstream := *c.upstream.Load()
+	lookupCtx, rec := nestUpstream(ctx)
+	for _, candidate := range c.conf.Load().candidates(name) {
+		rrs, ttl, err = lookupRecordsTTL(lookupCtx, upstream, rtype, candidate)
+		if !isNotFound(err) {
+			reportUpstream(ctx, upstream, rec)
+			return rrs, ttl, err
+		}
+	}
+	reportUpstream(ctx, upstream, rec)
+	return nil, 0, errNoSuchHost(name)
+}
+
+// ConfigureFromResolvConf sets the upstream resolver to query the
+// nameservers of the resolv.conf file at path, in order, applying its
+// search domains and its ndots, timeout and attempts options. It must be
+// called before the first lookup, as it also sets RetryAttempts.
+func (r *Resolver) ConfigureFromResolvConf(path string) error {
+	_, err := r.configureFromResolvConf(path)
+	return err
+}
+
+// WatchResolvConf is like ConfigureFromResolvConf but also checks the file
+// at interval and applies its new settings when it changes, until Close.
+func (r *Resolver) WatchResolvConf(path string, interval time.Duration) error {
+	c, err := r.configureFromResolvConf(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	r.once.Do(r.init)
+	r.loops.Add(1)
+	go r.resolvConfLoop(r.clock().NewTicker(interval), c, path, info.ModTime())
+	return nil
+}
+
+func (r *Resolver) configureFromResolvConf(path string) (*resolvConfResolver, error) {
+	conf, err := readResolvConf(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &resolvConfResolver{}
+	c.set(conf)
+	r.SetResolver(c)
+	r.RetryAttempts = conf.attempts - 1
+	return c, nil
+}
+
+func (r *Resolver) resolvConfLoop(t Ticker, c *resolvConfResolver, path string, modTime time.Time) {
+	defer r.loops.Done()
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-t.C():
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		// Keep the current settings if the new file cannot be read, e.g.
+		// while it is being replaced.
+		if conf, err := readResolvConf(path); err == nil {
+			c.set(conf)
+			modTime = info.ModTime()
+		}
+	}
+}
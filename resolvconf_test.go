@@ -0,0 +1,108 @@
+package dnscache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf, err := parseResolvConf(strings.NewReader(`# generated
+nameserver 10.0.0.53
+nameserver 2001:db8::53 ; secondary
+nameserver invalid
+domain ignored.example
+search svc.cluster.local cluster.local.
+options ndots:5 timeout:2 attempts:3 rotate
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &resolvConf{
+		servers:  []string{"10.0.0.53:53", "[2001:db8::53]:53"},
+		search:   []string{"svc.cluster.local", "cluster.local"},
+		ndots:    5,
+		timeout:  2 * time.Second,
+		attempts: 3,
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("parseResolvConf = %+v, want %+v", conf, want)
+	}
+
+	conf, err = parseResolvConf(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.servers, []string{"127.0.0.1:53"}) || conf.ndots != 1 || conf.timeout != 5*time.Second {
+		t.Errorf("defaults = %+v", conf)
+	}
+}
+
+func TestResolvConf_Candidates(t *testing.T) {
+	conf := &resolvConf{search: []string{"a.example", "b.example"}, ndots: 2}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"db", []string{"db.a.example", "db.b.example", "db"}},
+		{"db.svc.example", []string{"db.svc.example", "db.svc.example.a.example", "db.svc.example.b.example"}},
+		{"db.", []string{"db."}},
+		{"192.0.2.1", []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		if got := conf.candidates(tt.host); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("candidates(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestResolver_WatchResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	write := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("nameserver 127.0.0.1\nsearch test\noptions attempts:3\n", time.Unix(1, 0))
+
+	clock := newFakeClock()
+	r := &Resolver{Clock: clock}
+	defer r.Close()
+	if err := r.WatchResolvConf(path, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c := r.upstream().(*resolvConfResolver)
+	if r.RetryAttempts != 2 {
+		t.Errorf("RetryAttempts = %d, want 2", r.RetryAttempts)
+	}
+
+	// The test server only knows example.test, reached through the search
+	// domain once the reloaded settings point to it.
+	c.conf.Load().servers = []string{startTestDNSServer(t, testRawHandler)}
+	c.set(c.conf.Load())
+	addrs, err := r.LookupHost(context.Background(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 3 {
+		t.Errorf("LookupHost(example) = %v, want the 3 addresses of example.test", addrs)
+	}
+
+	write("nameserver 127.0.0.2\n", time.Unix(2, 0))
+	clock.Advance(time.Second)
+	deadline := time.Now().Add(time.Second)
+	for len(c.conf.Load().search) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if conf := c.conf.Load(); !reflect.DeepEqual(conf.servers, []string{"127.0.0.2:53"}) || len(conf.search) != 0 {
+		t.Errorf("reloaded settings = %+v", conf)
+	}
+}
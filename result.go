@@ -0,0 +1,53 @@
+package dnscache
+
+import (
+	"context"
+	"time"
+)
+
+// Result describes how a lookup was answered, see LookupHostWithInfo.
+type Result struct {
+	// Addrs are the addresses of the host.
+	Addrs []string
+
+	// Cached reports whether the addresses came from the cache, including
+	// static entries, as opposed to an upstream lookup made for this call.
+	Cached bool
+
+	// Stale reports whether the cached addresses were served past their
+	// expiry, see ExpiredPolicy, or because the upstream lookup failed.
+	Stale bool
+
+	// Age is how long ago the cached addresses were last resolved, zero
+	// for addresses resolved by this call and for static entries.
+	Age time.Duration
+}
+
+// LookupHostWithInfo is like LookupHost but also reports whether the
+// addresses came from the cache and how old they are, so that callers can
+// attribute latency to DNS or to the cache.
+func (r *Resolver) LookupHostWithInfo(ctx context.Context, host string) (res Result, err error) {
+	r.once.Do(r.init)
+	if host, err = toASCII(host); err != nil {
+		return res, err
+	}
+	addrs, err := r.lookup(ctx, cacheKey{rtype: TypeHost, name: host}, 0, &res)
+	res.Addrs = r.shuffle(host, addrs)
+	return res, err
+}
+
+// resultFromCache fills res, if not nil, for an answer from the entry of
+// key.
+func (r *Resolver) resultFromCache(res *Result, key cacheKey, stale bool) {
+	if res == nil {
+		return
+	}
+	res.Cached = true
+	res.Stale = stale
+	s := r.shard(key)
+	s.mu.RLock()
+	if entry, found := s.entries[key]; found {
+		res.Age = r.now().Sub(entry.refreshed)
+	}
+	s.mu.RUnlock()
+}
@@ -0,0 +1,50 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolver_LookupHostWithInfo(t *testing.T) {
+	clock := newFakeClock()
+	r := &Resolver{Resolver: ttlResolver{ttl: time.Minute}, Clock: clock}
+	ctx := context.Background()
+
+	res, err := r.LookupHostWithInfo(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cached || res.Stale || res.Age != 0 || len(res.Addrs) == 0 {
+		t.Errorf("first lookup: %+v, want fresh addresses", res)
+	}
+
+	clock.Advance(10 * time.Second)
+	res, err = r.LookupHostWithInfo(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Cached || res.Stale || res.Age != 10*time.Second {
+		t.Errorf("cached lookup: %+v, want a hit aged 10s", res)
+	}
+
+	// Past the TTL, the failing upstream makes the cached addresses stale.
+	r.Resolver = ttlResolver{BadResolver: BadResolver{choke: true}, ttl: time.Minute}
+	clock.Advance(time.Minute)
+	res, err = r.LookupHostWithInfo(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Cached || !res.Stale || res.Age != 70*time.Second {
+		t.Errorf("lookup after upstream error: %+v, want a stale hit aged 70s", res)
+	}
+
+	r.Resolver = ttlResolver{ttl: time.Minute}
+	res, err = r.LookupHostWithInfo(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cached || res.Stale {
+		t.Errorf("lookup after recovery: %+v, want fresh addresses", res)
+	}
+}
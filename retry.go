@@ -0,0 +1,60 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// defaultRetryBaseDelay is the default of Resolver.RetryBaseDelay.
+const defaultRetryBaseDelay = 50 * time.Millisecond
+
+// retry calls lookup until it succeeds, fails with a permanent error,
+// RetryAttempts retries were made or ctx, the context of the lookup, is
+// done.
+func (r *Resolver) retry(ctx context.Context, lookup func() (lookupResult, error)) (lr lookupResult, err error) {
+	delay := r.RetryBaseDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	for attempt := 0; ; attempt++ {
+		lr, err = lookup()
+		if err == nil || attempt >= r.RetryAttempts || !isTemporary(err) || ctx.Err() != nil {
+			return lr, err
+		}
+		r.stats.retries.Add(1)
+		r.sleep(ctx, jitter(delay, r.RetryJitter))
+		if ctx.Err() != nil {
+			return lr, err
+		}
+		delay *= 2
+	}
+}
+
+// isTemporary reports whether err is a transient failure worth retrying,
+// such as a timeout or a dropped packet. The timeout of an attempt is one,
+// retry checks that the lookup itself did not time out.
+func isTemporary(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// jitter randomly moves d by up to the fraction f of its value.
+func jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 {
+		return d
+	}
+	if f > 1 {
+		f = 1
+	}
+	return d + time.Duration(f*(2*rand.Float64()-1)*float64(d))
+}
@@ -0,0 +1,98 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolver_Retry(t *testing.T) {
+	f := &flakyResolver{
+		failures: 2,
+		err:      &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true},
+	}
+	r := &Resolver{Resolver: f, RetryAttempts: 2, RetryBaseDelay: time.Millisecond}
+
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatalf("LookupHost() = %v, want success after retries", err)
+	}
+	if f.calls != 3 {
+		t.Errorf("upstream called %d times, want 3", f.calls)
+	}
+	if n := r.Stats().Retries; n != 2 {
+		t.Errorf("Retries = %d, want 2", n)
+	}
+}
+
+func TestResolver_RetryPermanentError(t *testing.T) {
+	f := &flakyResolver{failures: 1, err: errNoSuchHost("example.com")}
+	r := &Resolver{Resolver: f, RetryAttempts: 3, RetryBaseDelay: time.Millisecond}
+
+	if _, err := r.LookupHost(context.Background(), "example.com"); !isNotFound(err) {
+		t.Errorf("err = %v, want not found", err)
+	}
+	if f.calls != 1 {
+		t.Errorf("upstream called %d times, want 1", f.calls)
+	}
+}
+
+func TestResolver_RetryCancelled(t *testing.T) {
+	newResolver := func(propagate bool) (*Resolver, *flakyResolver) {
+		f := &flakyResolver{
+			failures: 100,
+			err:      &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true},
+		}
+		return &Resolver{Resolver: f, RetryAttempts: 5, RetryBaseDelay: time.Hour, PropagateContext: propagate}, f
+	}
+	running := func(r *Resolver) int {
+		r.flightsMu.Lock()
+		defer r.flightsMu.Unlock()
+		return r.running
+	}
+
+	// The backoff stops with the context of the caller.
+	r, f := newResolver(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _ = r.LookupHost(ctx, "example.com")
+	deadline := time.Now().Add(time.Second)
+	for running(r) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := running(r); n != 0 {
+		t.Errorf("%d upstream lookups still backing off after the caller gave up", n)
+	}
+	if n := atomic.LoadInt32(&f.calls); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+
+	// And with Close for detached lookups.
+	r, _ = newResolver(false)
+	go func() { _, _ = r.LookupHost(context.Background(), "example.com") }()
+	for r.Stats().Retries == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("Close waited for the retry backoff")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second, 0.5); d < time.Second/2 || d > 3*time.Second/2 {
+			t.Fatalf("jitter(1s, 0.5) = %v, want within [0.5s, 1.5s]", d)
+		}
+	}
+	if d := jitter(time.Second, 0); d != time.Second {
+		t.Errorf("jitter(1s, 0) = %v, want 1s", d)
+	}
+}
@@ -0,0 +1,76 @@
+package dnscache
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// rfc6724Precedence is the default policy table of RFC 6724, section 2.1,
+// most specific prefixes first.
+var rfc6724Precedence = []struct {
+	prefix     netip.Prefix
+	precedence int
+}{
+	{netip.MustParsePrefix("::1/128"), 50},
+	{netip.MustParsePrefix("::ffff:0:0/96"), 35},
+	{netip.MustParsePrefix("::/96"), 1},
+	{netip.MustParsePrefix("2001::/32"), 5},
+	{netip.MustParsePrefix("2002::/16"), 30},
+	{netip.MustParsePrefix("3ffe::/16"), 1},
+	{netip.MustParsePrefix("fec0::/10"), 1},
+	{netip.MustParsePrefix("fc00::/7"), 3},
+	{netip.MustParsePrefix("::/0"), 40},
+}
+
+// precedence returns the RFC 6724 precedence of addr.
+func precedence(addr netip.Addr) int {
+	if addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+	}
+	for _, p := range rfc6724Precedence {
+		if p.prefix.Contains(addr) {
+			return p.precedence
+		}
+	}
+	return 0
+}
+
+// routable reports whether the host has a source address to reach addr,
+// which sends no packet.
+func routable(addr netip.Addr) bool {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, 9)))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// orderRFC6724 sorts addrs the way the system resolver would following the
+// destination address selection of RFC 6724: the unusable addresses, as
+// reported by usable, last (rule 1), then by decreasing precedence (rule
+// 6), e.g. global IPv6 addresses before IPv4 ones before ULAs. The order of
+// the addresses ranked equally is kept.
+func orderRFC6724(addrs []string, usable func(netip.Addr) bool) {
+	type rank struct {
+		usable     bool
+		precedence int
+	}
+	ranks := make(map[string]rank, len(addrs))
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		ranks[a] = rank{usable: usable(addr), precedence: precedence(addr)}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		ri, rj := ranks[addrs[i]], ranks[addrs[j]]
+		if ri.usable != rj.usable {
+			return ri.usable
+		}
+		return ri.precedence > rj.precedence
+	})
+}
@@ -0,0 +1,28 @@
+package dnscache
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestOrderRFC6724(t *testing.T) {
+	addrs := []string{"192.0.2.1", "fd00::1", "2001:db8::1", "192.0.2.2", "2001:db8::2", "::1"}
+	tests := []struct {
+		name   string
+		usable func(netip.Addr) bool
+		want   []string
+	}{
+		{"dual stack", func(netip.Addr) bool { return true },
+			[]string{"::1", "2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2", "fd00::1"}},
+		{"IPv4 only", func(addr netip.Addr) bool { return addr.Is4() },
+			[]string{"192.0.2.1", "192.0.2.2", "::1", "2001:db8::1", "2001:db8::2", "fd00::1"}},
+	}
+	for _, tt := range tests {
+		got := append([]string(nil), addrs...)
+		orderRFC6724(got, tt.usable)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: orderRFC6724() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package dnscache
+
+import "time"
+
+// setSource is the source reported for entries stored by Set.
+const setSource = "Set"
+
+// Set caches addrs for host as if LookupHost had just resolved them with a
+// TTL of ttl, e.g. to feed the cache with the addresses of a service known
+// from a discovery API without a DNS round trip. The entry then expires,
+// and is refreshed or evicted, like any other: the next refresh resolves
+// host upstream, so names unknown to the upstream resolver should be set
+// again before each refresh, or with SetStatic instead. A ttl of zero or
+// less means no expiry, and MinTTL and MaxTTL apply otherwise. The entries
+// of host limited to one address family by LookupNetHost, or cached for a
+// client subnet by ContextWithClientSubnet, are deleted, so that they are
+// resolved again. Passing no addrs is like Remove.
+func (r *Resolver) Set(host string, addrs []string, ttl time.Duration) {
+	r.once.Do(r.init)
+	host = asciiName(host)
+	if len(addrs) == 0 {
+		r.removeHost(host)
+		r.publish(HostRemoved, host, nil)
+		return
+	}
+	lr := lookupResult{
+		rrs:    append([]string(nil), addrs...),
+		ttl:    ttl,
+		hasTTL: ttl > 0,
+		source: setSource,
+	}
+	for _, rtype := range [...]RecordType{TypeA, TypeAAAA} {
+		for _, key := range r.keysOf(rtype, host) {
+			r.forgetKey(key)
+		}
+	}
+	for _, key := range r.keysOf(TypeHost, host)[1:] {
+		r.forgetKey(key)
+	}
+	// Set entries count as used so that the next Refresh updates them
+	// instead of purging them.
+	r.store(cacheKey{rtype: TypeHost, name: host}, lr, true)
+}
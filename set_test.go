@@ -0,0 +1,49 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_Set(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("svc.example.com", "192.0.2.1")
+	r := &Resolver{Resolver: upstream, Clock: clock}
+
+	addrs := []string{"10.0.0.1", "10.0.0.2"}
+	r.Set("svc.example.com", addrs, time.Minute)
+	addrs[0] = "10.0.0.3"
+	got, err := r.LookupHost(ctx, "svc.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameAddrs(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("LookupHost() = %v, want the addresses set", got)
+	}
+	if n := upstream.Calls("svc.example.com"); n != 0 {
+		t.Errorf("upstream called %d times, want none", n)
+	}
+	infos := r.Entries()
+	if len(infos) != 1 || infos[0].Source != setSource || !infos[0].Expires.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("Entries() = %+v, want one entry set expiring in 1m", infos)
+	}
+
+	// The entry resolves upstream once expired, like any other.
+	clock.Advance(2 * time.Minute)
+	if got, err = r.LookupHost(ctx, "svc.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "192.0.2.1" {
+		t.Errorf("expired LookupHost() = %v, want [192.0.2.1]", got)
+	}
+
+	r.Set("svc.example.com", nil, 0)
+	if n := r.len(); n != 0 {
+		t.Errorf("cache holds %d entries after setting no addresses, want 0", n)
+	}
+}
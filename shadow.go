@@ -0,0 +1,127 @@
+package dnscache
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// shadowTimeout bounds shadow lookups when Resolver.Timeout is not set.
+	shadowTimeout = 5 * time.Second
+
+	// shadowConcurrency bounds the number of shadow lookups in flight, so
+	// that a slow shadow resolver cannot pile up goroutines.
+	shadowConcurrency = 16
+)
+
+// ShadowResult describes a LookupHost call which was also resolved through
+// the shadow resolver, see Resolver.ShadowSampleRate.
+type ShadowResult struct {
+	Host string
+
+	// Addrs is the answer of the upstream resolver the cached entry was
+	// built from, before the resolver transformed it, and Err and Latency
+	// describe the lookup served by the cache.
+	Addrs   []string
+	Err     error
+	Latency time.Duration
+
+	// ShadowAddrs, ShadowErr and ShadowLatency describe the answer of the
+	// shadow resolver.
+	ShadowAddrs   []string
+	ShadowErr     error
+	ShadowLatency time.Duration
+
+	// Diverged reports whether the two answers differ, either by their set
+	// of addresses or because only one of them failed.
+	Diverged bool
+}
+
+// shadowSampled reports whether the current lookup should be shadowed.
+func (r *Resolver) shadowSampled() bool {
+	return r.ShadowSampleRate > 0 && rand.Float64() < r.ShadowSampleRate
+}
+
+// shadowLookupHost performs a shadow lookup of the host of key in the
+// background, if it was answered upstream and fewer than shadowConcurrency
+// are in flight, and compares its answer with the upstream one.
+func (r *Resolver) shadowLookupHost(key cacheKey, err error, latency time.Duration) {
+	var addrs []string
+	if err == nil {
+		s := r.shard(key)
+		s.mu.RLock()
+		if entry, found := s.entries[key]; found {
+			addrs = entry.raw
+		}
+		s.mu.RUnlock()
+		if addrs == nil {
+			// Answered by SetStatic or Set, or cached before shadowing.
+			return
+		}
+	}
+	select {
+	case r.shadowSem <- struct{}{}:
+	default:
+		r.stats.shadowDropped.Add(1)
+		return
+	}
+	shadow := r.systemResolver()
+	if r.ShadowResolver != nil {
+		shadow = r.ShadowResolver
+	}
+	timeout := r.timeout()
+	if timeout <= 0 {
+		timeout = shadowTimeout
+	}
+
+	started := r.goTracked(func() {
+		defer func() { <-r.shadowSem }()
+		ctx, cancel := context.WithTimeout(r.ctx, timeout)
+		defer cancel()
+		if key.subnet != "" {
+			ctx = withSubnet(ctx, key.subnet)
+		}
+
+		res := ShadowResult{
+			Host:    key.name,
+			Addrs:   addrs,
+			Err:     err,
+			Latency: latency,
+		}
+		start := r.now()
+		res.ShadowAddrs, res.ShadowErr = shadow.LookupHost(ctx, key.name)
+		res.ShadowLatency = r.now().Sub(start)
+		res.Diverged = (res.Err == nil) != (res.ShadowErr == nil) || !sameAddrs(res.Addrs, res.ShadowAddrs)
+
+		r.stats.shadowLookups.Add(1)
+		if res.Diverged {
+			r.stats.shadowDivergences.Add(1)
+		}
+		if r.OnShadowResult != nil {
+			r.OnShadowResult(res)
+		}
+	})
+	if !started {
+		<-r.shadowSem
+	}
+}
+
+// sameAddrs reports whether a and b hold the same set of addresses,
+// ignoring their order.
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
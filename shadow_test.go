@@ -0,0 +1,105 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestResolver_Shadow(t *testing.T) {
+	results := make(chan ShadowResult, 2)
+	r := &Resolver{
+		Resolver:         BadResolver{},
+		ShadowSampleRate: 1,
+		ShadowResolver:   &slowResolver{},
+		OnShadowResult:   func(res ShadowResult) { results <- res },
+	}
+
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case res := <-results:
+		if res.Host != "example.com" || !res.Diverged {
+			t.Errorf("result = %+v, want diverged example.com", res)
+		}
+		if len(res.ShadowAddrs) != 1 || res.ShadowAddrs[0] != "192.0.2.1" {
+			t.Errorf("ShadowAddrs = %v, want [192.0.2.1]", res.ShadowAddrs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnShadowResult not called")
+	}
+
+	stats := r.Stats()
+	if stats.ShadowLookups != 1 || stats.ShadowDivergences != 1 {
+		t.Errorf("ShadowLookups, ShadowDivergences = %d, %d, want 1, 1", stats.ShadowLookups, stats.ShadowDivergences)
+	}
+}
+
+func TestSameAddrs(t *testing.T) {
+	if !sameAddrs([]string{"192.0.2.1", "192.0.2.2"}, []string{"192.0.2.2", "192.0.2.1"}) {
+		t.Error("same addresses in a different order reported as different")
+	}
+	if sameAddrs([]string{"192.0.2.1"}, []string{"192.0.2.2"}) {
+		t.Error("different addresses reported as same")
+	}
+}
+
+func TestResolver_ShadowUpstreamAnswer(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1", "192.0.2.2")
+	upstream.SetHost("set.example.com", "192.0.2.3")
+	results := make(chan ShadowResult, 2)
+	r := &Resolver{
+		Resolver:         upstream,
+		MaxAddrsPerEntry: 1,
+		ShadowSampleRate: 1,
+		ShadowResolver:   upstream,
+		OnShadowResult:   func(res ShadowResult) { results <- res },
+	}
+	defer r.Close()
+
+	// The addresses dropped by MaxAddrsPerEntry are no divergence.
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case res := <-results:
+		if res.Diverged || len(res.Addrs) != 2 {
+			t.Errorf("result = %+v, want the full upstream answer, not diverged", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnShadowResult not called")
+	}
+
+	// Addresses set by hand are not shadowed.
+	r.Set("set.example.com", []string{"10.0.0.1"}, time.Minute)
+	if _, err := r.LookupHost(context.Background(), "set.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Close()
+	select {
+	case res := <-results:
+		t.Errorf("set entry shadowed: %+v", res)
+	default:
+	}
+}
+
+func TestResolver_ShadowConcurrency(t *testing.T) {
+	r := &Resolver{
+		Resolver:         BadResolver{},
+		ShadowSampleRate: 1,
+		ShadowResolver:   blockingResolver{},
+	}
+	defer r.Close()
+	for i := 0; i < shadowConcurrency+4; i++ {
+		if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := r.Stats().ShadowDropped; n != 4 {
+		t.Errorf("ShadowDropped = %d, want 4", n)
+	}
+}
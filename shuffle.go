@@ -0,0 +1,48 @@
+package dnscache
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Shuffler reorders the addresses returned by lookups of host, see
+// Resolver.Shuffler. Shuffle reorders addrs in place; it is passed a copy of
+// the cached addresses and may be called concurrently.
+type Shuffler interface {
+	Shuffle(host string, addrs []string)
+}
+
+// RandomShuffler is a Shuffler putting the addresses in a uniformly random
+// order. Its randomness comes from a seed, so that tests relying on the
+// order of the addresses are reproducible.
+type RandomShuffler struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewRandomShuffler returns a RandomShuffler seeded with seed.
+func NewRandomShuffler(seed int64) *RandomShuffler {
+	return &RandomShuffler{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Shuffle implements Shuffler.
+func (s *RandomShuffler) Shuffle(host string, addrs []string) {
+	s.mu.Lock()
+	s.rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	s.mu.Unlock()
+}
+
+// shuffle returns addrs reordered by Shuffler, if any. addrs is copied
+// first unless it already is a copy made for CopyResults.
+func (r *Resolver) shuffle(host string, addrs []string) []string {
+	if r.Shuffler == nil || len(addrs) < 2 {
+		return addrs
+	}
+	if !r.CopyResults {
+		addrs = append([]string(nil), addrs...)
+	}
+	r.Shuffler.Shuffle(host, addrs)
+	return addrs
+}
@@ -0,0 +1,41 @@
+package dnscache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestResolver_Shuffler(t *testing.T) {
+	upstream := addrsResolver{addrs: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}}
+	lookups := func(seed int64) [][]string {
+		r := &Resolver{Resolver: upstream, Shuffler: NewRandomShuffler(seed)}
+		var all [][]string
+		for i := 0; i < 10; i++ {
+			addrs, err := r.LookupHost(context.Background(), "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameAddrs(addrs, upstream.addrs) {
+				t.Fatalf("addrs = %v, want a permutation of %v", addrs, upstream.addrs)
+			}
+			all = append(all, addrs)
+		}
+		if cached, _ := r.Peek("example.com"); !reflect.DeepEqual(cached, upstream.addrs) {
+			t.Errorf("cached addrs = %v, want the upstream order %v", cached, upstream.addrs)
+		}
+		return all
+	}
+
+	first := lookups(1)
+	if again := lookups(1); !reflect.DeepEqual(first, again) {
+		t.Errorf("orders differ with the same seed:\n%v\n%v", first, again)
+	}
+	shuffled := false
+	for _, addrs := range first {
+		shuffled = shuffled || !reflect.DeepEqual(addrs, upstream.addrs)
+	}
+	if !shuffled {
+		t.Error("addresses were never shuffled")
+	}
+}
@@ -0,0 +1,111 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// snapshotVersion is the format version written by Snapshot. Restore
+// rejects snapshots of other versions.
+const snapshotVersion = 1
+
+// snapshotSource is the source reported for entries loaded by Restore.
+const snapshotSource = "snapshot"
+
+type snapshot struct {
+	Version int             `json:"version"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	// Kind is "host" for LookupHost entries, "host4" and "host6" for
+	// LookupNetHost ones, "addr" for LookupAddr ones and "cname", "mx",
+	// "srv" and "txt" for the other types of LookupRecords.
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Subnet  string    `json:"subnet,omitempty"`
+	Records []string  `json:"records"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+var snapshotKinds = map[RecordType]string{
+	TypeHost:  "host",
+	TypeA:     "host4",
+	TypeAAAA:  "host6",
+	TypePTR:   "addr",
+	TypeCNAME: "cname",
+	TypeMX:    "mx",
+	TypeSRV:   "srv",
+	TypeTXT:   "txt",
+}
+
+// Snapshot returns the cached entries encoded as JSON, suitable for Restore.
+// It lets a service persist resolved addresses across restarts.
+func (r *Resolver) Snapshot() ([]byte, error) {
+	r.once.Do(r.init)
+	snap := snapshot{
+		Version: snapshotVersion,
+		Entries: make([]snapshotEntry, 0, r.len()),
+	}
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		for key, entry := range s.entries {
+			if entry.negErr != nil {
+				continue
+			}
+			snap.Entries = append(snap.Entries, snapshotEntry{
+				Kind:    snapshotKinds[key.rtype],
+				Name:    key.name,
+				Subnet:  key.subnet,
+				Records: append([]string(nil), entry.answer...),
+				Expires: entry.expires,
+			})
+		}
+		s.mu.RUnlock()
+	}
+	return json.Marshal(snap)
+}
+
+// Restore loads entries from a snapshot produced by Snapshot, overwriting
+// cached entries for the same names. Restored entries keep their expiry:
+// once expired, they are handled by ExpiredPolicy like any other entry, and
+// still served if resolving them again fails, unless OnLookupError is
+// ReturnError, so a service comes up warm while its upstream DNS is
+// unavailable.
+func (r *Resolver) Restore(data []byte) error {
+	r.once.Do(r.init)
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("dnscache: unsupported snapshot version %d", snap.Version)
+	}
+
+	keys := make([]cacheKey, len(snap.Entries))
+	for i, e := range snap.Entries {
+		rtype, found := snapshotKind(e.Kind)
+		if !found {
+			return fmt.Errorf("dnscache: unknown snapshot entry kind %q", e.Kind)
+		}
+		keys[i] = cacheKey{rtype: rtype, name: e.Name, subnet: e.Subnet}
+	}
+	for i, e := range snap.Entries {
+		// Restored entries count as used so that the next Refresh updates
+		// them instead of purging them.
+		r.storeExpiring(keys[i], lookupResult{rrs: e.Records, source: snapshotSource}, e.Expires, true)
+	}
+	return nil
+}
+
+// snapshotKind returns the record type of cache entries whose snapshot kind is name.
+func snapshotKind(name string) (rtype RecordType, found bool) {
+	for rtype, n := range snapshotKinds {
+		if n == name {
+			return rtype, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolver_SnapshotRestore(t *testing.T) {
+	src := &Resolver{Resolver: BadResolver{}}
+	if _, err := src.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The restored resolver must serve the entries without going upstream.
+	f := &fakeResolver{}
+	dst := &Resolver{Resolver: f}
+	if err := dst.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := dst.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "216.58.192.238" {
+		t.Errorf("addrs = %v, want [216.58.192.238]", addrs)
+	}
+	if _, err := dst.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if f.LookupHostCalls != 0 || f.LookupAddrCalls != 0 {
+		t.Errorf("upstream called %d/%d times, want none", f.LookupHostCalls, f.LookupAddrCalls)
+	}
+
+	// A failing refresh keeps serving the restored entry.
+	dst.Refresh()
+	if dst.entry("hexample.com") == nil {
+		t.Error("restored entry dropped by a failing refresh")
+	}
+}
+
+func TestResolver_RestoreInvalid(t *testing.T) {
+	r := &Resolver{}
+	for _, data := range []string{
+		`{`,
+		`{"version":2}`,
+		`{"version":1,"entries":[{"kind":"naptr","name":"example.com"}]}`,
+	} {
+		if err := r.Restore([]byte(data)); err == nil {
+			t.Errorf("Restore(%s) succeeded, want error", data)
+		}
+	}
+}
+
+func TestResolver_RestoreExpired(t *testing.T) {
+	data := []byte(`{"version":1,"entries":[{"kind":"host","name":"example.com","records":["192.0.2.1"],"expires":"2000-01-01T00:00:00Z"}]}`)
+	for _, tc := range []struct {
+		policy LookupErrorPolicy
+		served bool
+	}{
+		{ServeStale, true},
+		{ReturnError, false},
+	} {
+		f := &fakeResolver{}
+		r := &Resolver{Resolver: f, OnLookupError: tc.policy}
+		if err := r.Restore(data); err != nil {
+			t.Fatal(err)
+		}
+		addrs, err := r.LookupHost(context.Background(), "example.com")
+		if f.LookupHostCalls != 1 {
+			t.Errorf("OnLookupError = %v: upstream called %d times, want the expired entry resolved again", tc.policy, f.LookupHostCalls)
+		}
+		if served := err == nil && len(addrs) == 1 && addrs[0] == "192.0.2.1"; served != tc.served {
+			t.Errorf("OnLookupError = %v: LookupHost() = %v, %v, want the restored entry served: %v", tc.policy, addrs, err, tc.served)
+		}
+	}
+}
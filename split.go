@@ -0,0 +1,92 @@
+package dnscache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// SplitResolver is a DNSResolver sending each lookup to the upstream routed
+// for the domain of the name, as needed in split-horizon DNS environments,
+// e.g. "internal" to a corporate resolver and everything else to Default.
+type SplitResolver struct {
+	// Routes maps domain suffixes to the upstream resolving them and their
+	// subdomains. "internal", ".internal" and "*.internal" are equivalent.
+	// The longest matching suffix wins. Reverse lookups are routed by their
+	// reverse name, e.g. "10.in-addr.arpa" for 10.0.0.0/8.
+	Routes map[string]DNSResolver
+
+	// Default resolves the names matching no route. If nil, the system
+	// resolver is used.
+	Default DNSResolver
+}
+
+// LookupHost looks up host on the upstream routed for it.
+func (s *SplitResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	addrs, _, err = s.LookupHostTTL(ctx, host)
+	return
+}
+
+// LookupAddr looks up addr on the upstream routed for its reverse name.
+func (s *SplitResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	names, _, err = s.LookupAddrTTL(ctx, addr)
+	return
+}
+
+// LookupHostTTL is like LookupHost but also returns the TTL reported by the
+// upstream, or NoTTL if it does not implement TTLResolver.
+func (s *SplitResolver) LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error) {
+	res := s.route(host)
+	routeCtx, rec := nestUpstream(ctx)
+	addrs, ttl, err = lookupHostTTL(routeCtx, res, host)
+	reportUpstream(ctx, res, rec)
+	return addrs, ttl, err
+}
+
+// LookupAddrTTL is like LookupAddr but also returns the TTL reported by the
+// upstream, or NoTTL if it does not implement TTLResolver.
+func (s *SplitResolver) LookupAddrTTL(ctx context.Context, addr string) (names []string, ttl time.Duration, err error) {
+	arpa, err := reverseName(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	res := s.route(arpa)
+	routeCtx, rec := nestUpstream(ctx)
+	names, ttl, err = lookupAddrTTL(routeCtx, res, addr)
+	reportUpstream(ctx, res, rec)
+	return names, ttl, err
+}
+
+// LookupRecords looks up the records of name on the upstream routed for it.
+func (s *SplitResolver) LookupRecords(ctx context.Context, rtype RecordType, name string) (rrs []string, ttl time.Duration, err error) {
+	res := s.route(name)
+	routeCtx, rec := nestUpstream(ctx)
+	rrs, ttl, err = lookupRecordsTTL(routeCtx, res, rtype, name)
+	reportUpstream(ctx, res, rec)
+	return rrs, ttl, err
+}
+
+// route returns the upstream of name.
+func (s *SplitResolver) route(name string) DNSResolver {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var (
+		best    DNSResolver
+		bestLen = -1
+	)
+	for suffix, res := range s.Routes {
+		suffix = strings.ToLower(strings.Trim(strings.TrimPrefix(suffix, "*"), "."))
+		if len(suffix) <= bestLen {
+			continue
+		}
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			best, bestLen = res, len(suffix)
+		}
+	}
+	if best != nil {
+		return best
+	}
+	if s.Default != nil {
+		return s.Default
+	}
+	return defaultResolver
+}
@@ -0,0 +1,72 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/minio/dnscache/dnscachetest"
+)
+
+func TestSplitResolver(t *testing.T) {
+	internal := addrsResolver{addrs: []string{"10.0.0.1"}}
+	corp := addrsResolver{addrs: []string{"10.0.1.1"}}
+	public := addrsResolver{addrs: []string{"192.0.2.1"}}
+	s := &SplitResolver{
+		Routes: map[string]DNSResolver{
+			"internal":        internal,
+			"*.corp.internal": corp,
+		},
+		Default: public,
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"internal", "10.0.0.1"},
+		{"db.internal", "10.0.0.1"},
+		{"DB.Internal.", "10.0.0.1"},
+		{"git.corp.internal", "10.0.1.1"},
+		{"notinternal", "192.0.2.1"},
+		{"example.com", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		addrs, err := s.LookupHost(context.Background(), tt.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || addrs[0] != tt.want {
+			t.Errorf("LookupHost(%q) = %v, want [%s]", tt.host, addrs, tt.want)
+		}
+	}
+
+	reverse := &SplitResolver{
+		Routes:  map[string]DNSResolver{"10.in-addr.arpa": dnscachetest.NewResolver()},
+		Default: BadResolver{},
+	}
+	if _, err := reverse.LookupAddr(context.Background(), "10.1.2.3"); !isNotFound(err) {
+		t.Errorf("LookupAddr(10.1.2.3) err = %v, want routed not found", err)
+	}
+	if _, err := reverse.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Errorf("LookupAddr(192.0.2.1) err = %v, want default answer", err)
+	}
+}
+
+func TestResolver_SplitUpstreamWithoutTTL(t *testing.T) {
+	upstream := dnscachetest.NewResolver()
+	upstream.SetHost("example.com", "192.0.2.1")
+	r := &Resolver{Resolver: &SplitResolver{Default: upstream}}
+	for i := 0; i < 5; i++ {
+		if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := upstream.Calls("example.com"); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+	if hits := r.Stats().Hits; hits != 4 {
+		t.Errorf("Hits = %d, want 4", hits)
+	}
+	if infos := r.Entries(); len(infos) != 1 || !infos[0].Expires.IsZero() {
+		t.Errorf("Entries() = %+v, want one entry without expiry", infos)
+	}
+}
@@ -0,0 +1,114 @@
+package dnscache
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// maxExpandedCIDR bounds the number of addresses ExpandCIDR returns.
+const maxExpandedCIDR = 1 << 16
+
+// staticHosts is an immutable table of static host entries. It is replaced
+// as a whole on every change so that lookups can read it without locking.
+type staticHosts struct {
+	hosts map[string][]string
+
+	// wildcards maps the suffix of "*.suffix" patterns to their addresses.
+	wildcards map[string][]string
+}
+
+// SetStatic makes LookupHost answer addrs for host without querying the
+// upstream resolver, like an /etc/hosts entry. Static entries are never
+// refreshed nor evicted and take precedence over cached ones. Passing no
+// addrs removes the static entry.
+//
+// A host of the form "*.example.com" matches every name below example.com,
+// but not example.com itself. Exact entries take precedence over wildcards,
+// and longer wildcards over shorter ones. Use ExpandCIDR to map a pattern to
+// a whole subnet.
+func (r *Resolver) SetStatic(host string, addrs []string) {
+	r.staticMu.Lock()
+	defer r.staticMu.Unlock()
+
+	next := &staticHosts{
+		hosts:     make(map[string][]string),
+		wildcards: make(map[string][]string),
+	}
+	if cur := r.static.Load(); cur != nil {
+		for h, a := range cur.hosts {
+			next.hosts[h] = a
+		}
+		for h, a := range cur.wildcards {
+			next.wildcards[h] = a
+		}
+	}
+	host = canonicalHost(host)
+	table := next.hosts
+	if strings.HasPrefix(host, "*.") {
+		host = host[2:]
+		table = next.wildcards
+	}
+	host = asciiName(host)
+	if len(addrs) == 0 {
+		delete(table, host)
+	} else {
+		table[host] = append([]string(nil), addrs...)
+	}
+	if len(next.hosts) == 0 && len(next.wildcards) == 0 {
+		next = nil
+	}
+	r.static.Store(next)
+}
+
+// ExpandCIDR returns every address of the cidr subnet, e.g. "10.0.0.0/24",
+// for use with SetStatic. It fails for subnets of more than 65536
+// addresses.
+func ExpandCIDR(cidr string) ([]string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 16 {
+		return nil, fmt.Errorf("dnscache: %s holds more than %d addresses", cidr, maxExpandedCIDR)
+	}
+	addrs := make([]string, 0, 1<<hostBits)
+	for a := prefix.Masked().Addr(); prefix.Contains(a); a = a.Next() {
+		addrs = append(addrs, a.String())
+	}
+	return addrs, nil
+}
+
+// RemoveStatic removes the static entry of host set by SetStatic.
+func (r *Resolver) RemoveStatic(host string) {
+	r.SetStatic(host, nil)
+}
+
+// loadStatic returns the static addresses of host, if any.
+func (r *Resolver) loadStatic(host string) (addrs []string, found bool) {
+	t := r.static.Load()
+	if t == nil {
+		return nil, false
+	}
+	host = canonicalHost(host)
+	if addrs, found = t.hosts[host]; found {
+		return
+	}
+	// Try the wildcards from the longest suffix to the shortest.
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if addrs, found = t.wildcards[host]; found {
+			return
+		}
+	}
+	return nil, false
+}
+
+// canonicalHost returns host lower cased and without trailing dot, so that
+// equivalent spellings of a name match. It does not allocate for names
+// already in canonical form.
+func canonicalHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
@@ -0,0 +1,86 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolver_SetStatic(t *testing.T) {
+	f := &fakeResolver{}
+	r := &Resolver{Resolver: f}
+	r.SetStatic("Pinned.Example.com.", []string{"10.0.0.1", "10.0.0.2"})
+
+	addrs, err := r.LookupHost(context.Background(), "pinned.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "10.0.0.1" || addrs[1] != "10.0.0.2" {
+		t.Errorf("addrs = %v, want [10.0.0.1 10.0.0.2]", addrs)
+	}
+	r.Refresh()
+	r.Refresh()
+	if _, err := r.LookupHost(context.Background(), "pinned.example.com"); err != nil {
+		t.Errorf("static entry lost after refresh: %v", err)
+	}
+	if f.LookupHostCalls != 0 {
+		t.Errorf("upstream called %d times, want none", f.LookupHostCalls)
+	}
+
+	r.RemoveStatic("pinned.example.com")
+	if _, err := r.LookupHost(context.Background(), "pinned.example.com"); err == nil {
+		t.Error("lookup of removed static entry did not go upstream")
+	}
+	if f.LookupHostCalls != 1 {
+		t.Errorf("upstream called %d times, want 1", f.LookupHostCalls)
+	}
+}
+
+func TestResolver_SetStaticWildcard(t *testing.T) {
+	r := &Resolver{Resolver: &fakeResolver{}}
+	r.SetStatic("*.example.com", []string{"10.0.0.1"})
+	r.SetStatic("*.internal.example.com", []string{"10.0.1.1"})
+	r.SetStatic("db.internal.example.com", []string{"10.0.2.1"})
+
+	for host, want := range map[string]string{
+		"www.example.com":            "10.0.0.1",
+		"a.b.example.com":            "10.0.0.1",
+		"api.internal.example.com":   "10.0.1.1",
+		"x.api.internal.example.com": "10.0.1.1",
+		"db.internal.example.com":    "10.0.2.1",
+	} {
+		addrs, err := r.LookupHost(context.Background(), host)
+		if err != nil {
+			t.Errorf("LookupHost(%s): %v", host, err)
+			continue
+		}
+		if len(addrs) != 1 || addrs[0] != want {
+			t.Errorf("LookupHost(%s) = %v, want [%s]", host, addrs, want)
+		}
+	}
+	if _, found := r.loadStatic("example.com"); found {
+		t.Error("wildcard matched its own apex")
+	}
+}
+
+func TestExpandCIDR(t *testing.T) {
+	addrs, err := ExpandCIDR("10.0.0.1/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	if len(addrs) != len(want) {
+		t.Fatalf("addrs = %v, want %v", addrs, want)
+	}
+	for i := range want {
+		if addrs[i] != want[i] {
+			t.Errorf("addrs[%d] = %s, want %s", i, addrs[i], want[i])
+		}
+	}
+
+	if _, err := ExpandCIDR("10.0.0.0/8"); err == nil {
+		t.Error("ExpandCIDR(10.0.0.0/8) succeeded, want error")
+	}
+	if _, err := ExpandCIDR("2001:db8::/120"); err != nil {
+		t.Errorf("ExpandCIDR(2001:db8::/120): %v", err)
+	}
+}
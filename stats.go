@@ -0,0 +1,173 @@
+package dnscache
+
+import "sync/atomic"
+
+// Stats holds counters describing the activity of a Resolver since it was
+// created.
+type Stats struct {
+	// Hits is the number of lookups answered from the cache.
+	Hits uint64
+
+	// NegativeHits is the number of cache hits answered with a cached
+	// error, see Resolver.ReverseNegativeTTL.
+	NegativeHits uint64
+
+	// Misses is the number of lookups which had to wait for an upstream
+	// lookup because the cache had no entry.
+	Misses uint64
+
+	// Lookups is the number of queries sent to the upstream resolver,
+	// including refreshes. Concurrent lookups merged together count once.
+	Lookups uint64
+
+	// LookupErrors is the number of upstream queries which failed.
+	LookupErrors uint64
+
+	// Retries is the number of upstream queries retried after a temporary
+	// error, see Resolver.RetryAttempts.
+	Retries uint64
+
+	// Timeouts is the number of callers whose context deadline expired while
+	// waiting on an upstream lookup.
+	Timeouts uint64
+
+	// WaitTimeouts is the number of lookups which gave up waiting after
+	// Resolver.LookupWaitTimeout.
+	WaitTimeouts uint64
+
+	// Forgets is the number of pending upstream lookups forgotten after a
+	// caller timed out, see Resolver.ForgetAfter. Each forget lets the next
+	// caller issue an additional upstream query.
+	Forgets uint64
+
+	// Expired is the number of lookups which found their entry past its
+	// TTL, see Resolver.ExpiredPolicy.
+	Expired uint64
+
+	// ExpiredEvictions is the number of expired entries deleted by the
+	// garbage collection, see Resolver.ExpiredGCInterval.
+	ExpiredEvictions uint64
+
+	// Evictions is the number of entries deleted to stay within
+	// MaxEntries.
+	Evictions uint64
+
+	// RefreshErrors is the number of entries whose refresh failed.
+	RefreshErrors uint64
+
+	// RefreshEvictions is the number of entries deleted after their refresh
+	// failed, see Resolver.RefreshErrorPolicy.
+	RefreshEvictions uint64
+
+	// RateLimited is the number of upstream lookups rejected with
+	// ErrRateLimited.
+	RateLimited uint64
+
+	// RateLimitWaits is the number of upstream lookups delayed to stay
+	// within Resolver.RateLimit.
+	RateLimitWaits uint64
+
+	// HealthCheckFailures is the number of health check dials which failed,
+	// see Resolver.HealthCheckInterval.
+	HealthCheckFailures uint64
+
+	// LookupQueueWaits is the number of upstream lookups which had to wait
+	// for their turn under Resolver.MaxConcurrentLookups, and
+	// LookupQueueTimeouts the number of them which gave up.
+	LookupQueueWaits    uint64
+	LookupQueueTimeouts uint64
+
+	// BreakerRejections is the number of upstream lookups not made because
+	// the circuit breaker of their name was open, see
+	// Resolver.BreakerThreshold.
+	BreakerRejections uint64
+
+	// ShadowLookups is the number of lookups compared against the shadow
+	// resolver, see Resolver.ShadowSampleRate.
+	ShadowLookups uint64
+
+	// ShadowDivergences is the number of shadow lookups whose answer
+	// differed from the one served by the cache.
+	ShadowDivergences uint64
+
+	// ShadowDropped is the number of lookups sampled for shadowing but not
+	// shadowed because too many shadow lookups were already in flight.
+	ShadowDropped uint64
+
+	// UpstreamAnswers is the number of answers, including "no such host"
+	// ones, of each upstream, by the name reported as EntryInfo.Upstream,
+	// e.g. to tell how often a FailoverResolver falls back. It is nil
+	// until the first answer.
+	UpstreamAnswers map[string]uint64
+}
+
+type resolverStats struct {
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	lookups      atomic.Uint64
+	lookupErrors atomic.Uint64
+	retries      atomic.Uint64
+	timeouts     atomic.Uint64
+	waitTimeouts atomic.Uint64
+	forgets      atomic.Uint64
+	expired      atomic.Uint64
+	evictions    atomic.Uint64
+
+	expiredEvictions atomic.Uint64
+
+	refreshErrors    atomic.Uint64
+	refreshEvictions atomic.Uint64
+
+	rateLimited    atomic.Uint64
+	rateLimitWaits atomic.Uint64
+
+	healthCheckFailures atomic.Uint64
+
+	lookupQueueWaits    atomic.Uint64
+	lookupQueueTimeouts atomic.Uint64
+
+	breakerRejections atomic.Uint64
+
+	shadowLookups     atomic.Uint64
+	shadowDivergences atomic.Uint64
+	shadowDropped     atomic.Uint64
+}
+
+// Stats returns a snapshot of the resolver counters.
+func (r *Resolver) Stats() Stats {
+	return Stats{
+		Hits:         r.stats.hits.Load(),
+		NegativeHits: r.stats.negativeHits.Load(),
+		Misses:       r.stats.misses.Load(),
+		Lookups:      r.stats.lookups.Load(),
+		LookupErrors: r.stats.lookupErrors.Load(),
+		Retries:      r.stats.retries.Load(),
+		Timeouts:     r.stats.timeouts.Load(),
+		WaitTimeouts: r.stats.waitTimeouts.Load(),
+		Forgets:      r.stats.forgets.Load(),
+		Expired:      r.stats.expired.Load(),
+		Evictions:    r.stats.evictions.Load(),
+
+		ExpiredEvictions: r.stats.expiredEvictions.Load(),
+
+		RefreshErrors:    r.stats.refreshErrors.Load(),
+		RefreshEvictions: r.stats.refreshEvictions.Load(),
+
+		RateLimited:    r.stats.rateLimited.Load(),
+		RateLimitWaits: r.stats.rateLimitWaits.Load(),
+
+		HealthCheckFailures: r.stats.healthCheckFailures.Load(),
+
+		LookupQueueWaits:    r.stats.lookupQueueWaits.Load(),
+		LookupQueueTimeouts: r.stats.lookupQueueTimeouts.Load(),
+
+		BreakerRejections: r.stats.breakerRejections.Load(),
+
+		ShadowLookups:     r.stats.shadowLookups.Load(),
+		ShadowDivergences: r.stats.shadowDivergences.Load(),
+		ShadowDropped:     r.stats.shadowDropped.Load(),
+
+		UpstreamAnswers: r.upstreamCounts(),
+	}
+}
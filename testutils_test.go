@@ -3,6 +3,13 @@ package dnscache
 import (
 	"context"
 	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 type BadResolver struct {
@@ -21,3 +28,219 @@ func (r BadResolver) LookupHost(ctx context.Context, host string) (addrs []strin
 	}
 	return
 }
+
+// testDNSHandler answers a single question with the given resources and rcode.
+type testDNSHandler func(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode)
+
+// answerQuery parses a packed query and builds the packed response returned
+// by handler.
+func answerQuery(query []byte, handler testDNSHandler) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 msg.ID,
+			Response:           true,
+			RecursionDesired:   msg.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: msg.Questions,
+	}
+	if len(msg.Questions) > 0 {
+		resp.Answers, resp.RCode = handler(msg.Questions[0])
+	}
+	return resp.Pack()
+}
+
+// startTestDNSServer serves handler over UDP on a local port and returns the
+// server address.
+func startTestDNSServer(t *testing.T, handler testDNSHandler) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp, err := answerQuery(buf[:n], handler)
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func testResource(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: body,
+	}
+}
+
+// entry returns the cache entry stored under the flat key, e.g.
+// "hexample.com", or nil if there is none.
+func (r *Resolver) entry(flat string) *cacheEntry {
+	key := cacheKey{rtype: RecordType(flat[0]), name: flat[1:]}
+	s := r.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[key]
+}
+
+// slowResolver answers every lookup with a fixed address after delay.
+type slowResolver struct {
+	delay time.Duration
+	calls int32
+}
+
+func (r *slowResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	atomic.AddInt32(&r.calls, 1)
+	time.Sleep(r.delay)
+	return []string{"host.example.com."}, nil
+}
+
+func (r *slowResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	atomic.AddInt32(&r.calls, 1)
+	time.Sleep(r.delay)
+	return []string{"192.0.2.1"}, nil
+}
+
+// blockingResolver blocks every lookup until its context is done.
+type blockingResolver struct{}
+
+func (blockingResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// notFoundResolver fails lookups for hosts in missing and answers the others
+// with BadResolver.
+type notFoundResolver struct {
+	BadResolver
+	missing map[string]bool
+}
+
+func (r notFoundResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	if r.missing[host] {
+		return nil, errNoSuchHost(host)
+	}
+	return r.BadResolver.LookupHost(ctx, host)
+}
+
+// flakyResolver fails the first failures lookups with err, then answers
+// like BadResolver.
+type flakyResolver struct {
+	BadResolver
+	failures int32
+	err      error
+	calls    int32
+}
+
+func (r *flakyResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	if atomic.AddInt32(&r.calls, 1) <= r.failures {
+		return nil, r.err
+	}
+	return r.BadResolver.LookupHost(ctx, host)
+}
+
+// fakeClock is a Clock whose time only moves forward with Advance.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+func (c *fakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, t := range c.waiters {
+		if t.when.After(c.now) {
+			waiters = append(waiters, t)
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		if t.period > 0 {
+			for !t.when.After(c.now) {
+				t.when = t.when.Add(t.period)
+			}
+			waiters = append(waiters, t)
+		}
+	}
+	c.waiters = waiters
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, w := range t.clock.waiters {
+		if w == t {
+			t.clock.waiters = append(t.clock.waiters[:i], t.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
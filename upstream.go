@@ -0,0 +1,88 @@
+package dnscache
+
+import (
+	"context"
+	"sync"
+)
+
+// upstreamKey is the context key of the upstreamRecorder of a lookup.
+type upstreamKey struct{}
+
+// upstreamRecorder collects the name of the upstream which answered a
+// lookup made through resolvers composed of others, e.g. FailoverResolver.
+type upstreamRecorder struct {
+	mu   sync.Mutex
+	name string
+}
+
+// withUpstreamRecorder returns a context in which the composite resolvers
+// record the upstream answering the lookup in the returned recorder.
+func withUpstreamRecorder(ctx context.Context) (context.Context, *upstreamRecorder) {
+	rec := new(upstreamRecorder)
+	return context.WithValue(ctx, upstreamKey{}, rec), rec
+}
+
+// answeredBy returns the name recorded in rec, or the name of resolver if
+// none of the resolvers it is made of recorded one.
+func (rec *upstreamRecorder) answeredBy(resolver DNSResolver) string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.name != "" {
+		return rec.name
+	}
+	return resolverName(resolver)
+}
+
+// nestUpstream prepares ctx for an attempt of a composite resolver on one
+// of its upstreams, so that failed attempts do not record anything. It
+// returns ctx as is, and rec nil, if the lookup records no upstream.
+func nestUpstream(ctx context.Context) (_ context.Context, rec *upstreamRecorder) {
+	if _, ok := ctx.Value(upstreamKey{}).(*upstreamRecorder); !ok {
+		return ctx, nil
+	}
+	return withUpstreamRecorder(ctx)
+}
+
+// reportUpstream records in ctx that res answered the lookup, or the
+// upstream recorded in rec by the attempt on res if res is itself
+// composite. The innermost upstream wins, e.g. the server of the
+// RawResolver a FailoverResolver fell back to.
+func reportUpstream(ctx context.Context, res DNSResolver, rec *upstreamRecorder) {
+	parent, ok := ctx.Value(upstreamKey{}).(*upstreamRecorder)
+	if !ok {
+		return
+	}
+	name := resolverName(res)
+	if rec != nil {
+		name = rec.answeredBy(res)
+	}
+	parent.mu.Lock()
+	parent.name = name
+	parent.mu.Unlock()
+}
+
+// countUpstream counts an answer of the upstream named name, see
+// Stats.UpstreamAnswers.
+func (r *Resolver) countUpstream(name string) {
+	r.upstreamsMu.Lock()
+	if r.upstreamAnswers == nil {
+		r.upstreamAnswers = make(map[string]uint64)
+	}
+	r.upstreamAnswers[name]++
+	r.upstreamsMu.Unlock()
+}
+
+// upstreamCounts returns a copy of the answers counted by countUpstream,
+// nil if there were none.
+func (r *Resolver) upstreamCounts() map[string]uint64 {
+	r.upstreamsMu.Lock()
+	defer r.upstreamsMu.Unlock()
+	if len(r.upstreamAnswers) == 0 {
+		return nil
+	}
+	counts := make(map[string]uint64, len(r.upstreamAnswers))
+	for name, n := range r.upstreamAnswers {
+		counts[name] = n
+	}
+	return counts
+}
@@ -0,0 +1,23 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLookupWaitTimeout is returned by lookups which waited longer than
+// Resolver.LookupWaitTimeout for their upstream lookup.
+var ErrLookupWaitTimeout = errors.New("dnscache: timed out waiting for upstream lookup")
+
+// waitTimer returns the channel on which a lookup waiting for its upstream
+// lookup gives up, nil if it waits forever, and the function releasing it.
+// Only lookups of callers are bounded: refreshes do not mark their entry
+// used and background revalidations run with the context of the resolver.
+func (r *Resolver) waitTimer(ctx context.Context, used bool) (<-chan time.Time, func()) {
+	if r.LookupWaitTimeout <= 0 || !used || ctx == r.ctx {
+		return nil, func() {}
+	}
+	t := r.clock().NewTimer(r.LookupWaitTimeout)
+	return t.C(), func() { t.Stop() }
+}
@@ -0,0 +1,70 @@
+package dnscache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stallingResolver answers like ttlResolver until stall is set, then blocks
+// every lookup until its context is done.
+type stallingResolver struct {
+	ttlResolver
+	stall atomic.Bool
+}
+
+func (r *stallingResolver) LookupHostTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if r.stall.Load() {
+		<-ctx.Done()
+		return nil, 0, ctx.Err()
+	}
+	return r.ttlResolver.LookupHostTTL(ctx, host)
+}
+
+func TestResolver_LookupWaitTimeout(t *testing.T) {
+	r := &Resolver{Resolver: blockingResolver{}, Timeout: time.Second, LookupWaitTimeout: 20 * time.Millisecond}
+	start := time.Now()
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != ErrLookupWaitTimeout {
+		t.Fatalf("err = %v, want %v", err, ErrLookupWaitTimeout)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("lookup waited %v, want about 20ms", d)
+	}
+	stats := r.Stats()
+	if stats.WaitTimeouts != 1 || stats.Forgets != 0 {
+		t.Errorf("WaitTimeouts = %d, Forgets = %d, want 1 and 0", stats.WaitTimeouts, stats.Forgets)
+	}
+
+	// The upstream lookup is still in flight and is joined.
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != ErrLookupWaitTimeout {
+		t.Fatalf("err = %v, want %v", err, ErrLookupWaitTimeout)
+	}
+	if n := r.Stats().Lookups; n != 1 {
+		t.Errorf("Lookups = %d, want 1", n)
+	}
+}
+
+func TestResolver_LookupWaitTimeoutServesStale(t *testing.T) {
+	upstream := &stallingResolver{ttlResolver: ttlResolver{ttl: 10 * time.Millisecond}}
+	r := &Resolver{Resolver: upstream, Timeout: time.Second, LookupWaitTimeout: 20 * time.Millisecond}
+	want, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream.stall.Store(true)
+	time.Sleep(20 * time.Millisecond)
+
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameAddrs(addrs, want) {
+		t.Errorf("addrs = %v, want the cached %v", addrs, want)
+	}
+
+	r.OnLookupError = ReturnError
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != ErrLookupWaitTimeout {
+		t.Errorf("err = %v, want %v", err, ErrLookupWaitTimeout)
+	}
+}
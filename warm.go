@@ -0,0 +1,78 @@
+package dnscache
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// warmConcurrency bounds the number of hosts Warm resolves at once.
+const warmConcurrency = 32
+
+// WarmError is returned by Warm when some hosts could not be resolved.
+type WarmError struct {
+	// Errors maps each host which failed to its lookup error.
+	Errors map[string]error
+}
+
+func (e *WarmError) Error() string {
+	hosts := make([]string, 0, len(e.Errors))
+	for host := range e.Errors {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var b strings.Builder
+	b.WriteString("dnscache: failed to resolve ")
+	b.WriteString(strconv.Itoa(len(hosts)))
+	b.WriteString(" host(s)")
+	for i, host := range hosts {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(host)
+		b.WriteString(": ")
+		b.WriteString(e.Errors[host].Error())
+	}
+	return b.String()
+}
+
+// Warm resolves hosts concurrently and caches the results, so that servers
+// can prime the cache for their known endpoints during startup. Hosts which
+// fail to resolve are reported by a *WarmError; the others stay cached.
+func (r *Resolver) Warm(ctx context.Context, hosts ...string) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs map[string]error
+		sem  = make(chan struct{}, warmConcurrency)
+	)
+	for _, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(host string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if _, err := r.LookupHost(ctx, host); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = make(map[string]error)
+				}
+				errs[host] = err
+				mu.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &WarmError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolver_Warm(t *testing.T) {
+	r := &Resolver{Resolver: notFoundResolver{missing: map[string]bool{"missing.example.com": true}}}
+
+	err := r.Warm(context.Background(), "a.example.com", "missing.example.com", "b.example.com")
+	werr, ok := err.(*WarmError)
+	if !ok {
+		t.Fatalf("err = %v, want *WarmError", err)
+	}
+	if len(werr.Errors) != 1 || werr.Errors["missing.example.com"] == nil {
+		t.Errorf("Errors = %v, want only missing.example.com", werr.Errors)
+	}
+	for _, key := range []string{"ha.example.com", "hb.example.com"} {
+		if r.entry(key) == nil {
+			t.Errorf("%s not cached", key)
+		}
+	}
+
+	if err := r.Warm(context.Background(), "a.example.com"); err != nil {
+		t.Errorf("Warm() = %v, want nil", err)
+	}
+}
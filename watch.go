@@ -0,0 +1,115 @@
+package dnscache
+
+import "context"
+
+// Watch returns a channel receiving the addresses of host: first the current
+// ones, then the new set every time it changes, e.g. after a refresh. The
+// channel is closed once ctx is done or the resolver is closed. Watched
+// hosts are kept in the cache and refreshed even if they are not looked up
+// otherwise.
+//
+// The addresses are those looked up without a client subnet: a subnet
+// attached to ctx with ContextWithClientSubnet is ignored.
+//
+// A slow receiver only misses intermediate sets: the channel always ends up
+// holding the latest one. An error is returned if the first lookup of host
+// fails.
+func (r *Resolver) Watch(ctx context.Context, host string) (<-chan []string, error) {
+	r.once.Do(r.init)
+	host, err := toASCII(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Register before looking host up, so that no change is missed.
+	c := make(chan []string, 1)
+	r.watchMu.Lock()
+	if r.watchers[host] == nil {
+		r.watchers[host] = make(map[chan []string]struct{})
+	}
+	r.watchers[host][c] = struct{}{}
+	r.watching.Add(1)
+	r.watchMu.Unlock()
+
+	addrs, err := r.LookupHost(withoutSubnet(ctx), host)
+	if err != nil {
+		r.unwatch(host, c)
+		return nil, err
+	}
+	r.watchMu.Lock()
+	if _, ok := r.watchers[host][c]; ok && len(c) == 0 {
+		// Otherwise a change newer than addrs is already pending, or c was
+		// closed by Close.
+		c <- addrs
+	}
+	r.watchMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.ctx.Done():
+		}
+		r.unwatch(host, c)
+	}()
+	return c, nil
+}
+
+// unwatch deregisters and closes c, a channel watching host, unless this
+// is already done.
+func (r *Resolver) unwatch(host string, c chan []string) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+	if _, ok := r.watchers[host][c]; !ok {
+		return
+	}
+	delete(r.watchers[host], c)
+	if len(r.watchers[host]) == 0 {
+		delete(r.watchers, host)
+	}
+	r.watching.Add(-1)
+	close(c)
+}
+
+// closeWatchers deregisters and closes the channels of all watchers.
+func (r *Resolver) closeWatchers() {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+	for host, cs := range r.watchers {
+		for c := range cs {
+			r.watching.Add(-1)
+			close(c)
+		}
+		delete(r.watchers, host)
+	}
+}
+
+// notifyWatchers sends addrs to the watchers of host, replacing any set they
+// have not received yet.
+func (r *Resolver) notifyWatchers(host string, addrs []string) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+	for c := range r.watchers[host] {
+		select {
+		case <-c:
+		default:
+		}
+		c <- addrs
+	}
+}
+
+// touchWatched marks the entries of watched hosts used, so that Refresh
+// keeps them.
+func (r *Resolver) touchWatched() {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+	for host := range r.watchers {
+		for _, key := range r.keysOf(TypeHost, host) {
+			s := r.shard(key)
+			s.mu.RLock()
+			if entry, found := s.entries[key]; found {
+				entry.used.Store(true)
+			}
+			s.mu.RUnlock()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package dnscache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolver_Watch(t *testing.T) {
+	upstream := &switchResolver{Resolver: BadResolver{}}
+	r := &Resolver{Resolver: upstream}
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := r.Watch(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recv := func(want string) {
+		t.Helper()
+		select {
+		case addrs := <-c:
+			if len(addrs) != 1 || addrs[0] != want {
+				t.Errorf("received %v, want [%s]", addrs, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("nothing received, want [%s]", want)
+		}
+	}
+	recv("216.58.192.238")
+
+	// The watched entry survives refreshes without lookups and reports
+	// changes only.
+	r.Refresh()
+	r.Refresh()
+	upstream.Resolver = &slowResolver{}
+	r.Refresh()
+	recv("192.0.2.1")
+	select {
+	case addrs := <-c:
+		t.Errorf("received unchanged addresses %v", addrs)
+	default:
+	}
+
+	cancel()
+	select {
+	case _, ok := <-c:
+		if ok {
+			t.Error("received addresses after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestResolver_WatchError(t *testing.T) {
+	r := &Resolver{Resolver: BadResolver{choke: true}}
+	if _, err := r.Watch(context.Background(), "example.com"); err == nil {
+		t.Error("Watch error = nil on lookup failure")
+	}
+	if n := r.watching.Load(); n != 0 {
+		t.Errorf("%d watchers left registered, want 0", n)
+	}
+}
@@ -0,0 +1,344 @@
+package dnscache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// exchangeFunc sends a packed DNS query to an upstream and returns the packed
+// response. It is the only transport specific part of the wire resolvers.
+type exchangeFunc func(ctx context.Context, query []byte) ([]byte, error)
+
+var errResponseMismatch = errors.New("dnscache: response does not match query")
+
+// ErrUnauthenticatedAnswer is returned by the wire resolvers requiring
+// DNSSEC when the server did not authenticate its answer.
+var ErrUnauthenticatedAnswer = errors.New("dnscache: answer not authenticated by DNSSEC")
+
+// wireOptions are the settings of a wire resolver applying to its queries.
+type wireOptions struct {
+	// dnssec requests DNSSEC validation and requires the AD bit in the
+	// response.
+	dnssec bool
+
+	// subnet, if not nil, is sent as EDNS Client Subnet.
+	subnet *net.IPNet
+}
+
+// ednsUDPSize is the UDP payload size advertised with EDNS(0), the size of
+// the RawResolver read buffer.
+const ednsUDPSize = 1232
+
+// lookupHostWire resolves host using A and AAAA queries sent through
+// exchange. A failure of one address family is ignored as long as the other
+// one returns addresses. The returned ttl is the smallest TTL of all records.
+func lookupHostWire(ctx context.Context, exchange exchangeFunc, host string, opts wireOptions) (addrs []string, ttl time.Duration, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		rrs      [2][]string
+		ttls     [2]time.Duration
+		errs     [2]error
+		families = [2]dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	)
+	wg.Add(len(families))
+	for i := range families {
+		go func(i int) {
+			defer wg.Done()
+			rrs[i], ttls[i], errs[i] = queryWire(ctx, exchange, host, families[i], opts)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range families {
+		if len(rrs[i]) == 0 {
+			continue
+		}
+		if len(addrs) == 0 || ttls[i] < ttl {
+			ttl = ttls[i]
+		}
+		addrs = append(addrs, rrs[i]...)
+	}
+	if len(addrs) > 0 {
+		return addrs, ttl, nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return nil, 0, errNoSuchHost(host)
+}
+
+// lookupAddrWire performs a PTR query for addr through exchange.
+func lookupAddrWire(ctx context.Context, exchange exchangeFunc, addr string, opts wireOptions) (names []string, ttl time.Duration, err error) {
+	arpa, err := reverseName(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	names, ttl, err = queryWire(ctx, exchange, arpa, dnsmessage.TypePTR, opts)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok {
+			dnsErr.Name = addr
+		}
+		return nil, 0, err
+	}
+	if len(names) == 0 {
+		return nil, 0, errNoSuchHost(addr)
+	}
+	return names, ttl, nil
+}
+
+// wireTypes maps the record types of RecordResolver to their query type.
+var wireTypes = map[RecordType]dnsmessage.Type{
+	TypeCNAME: dnsmessage.TypeCNAME,
+	TypeMX:    dnsmessage.TypeMX,
+	TypeSRV:   dnsmessage.TypeSRV,
+	TypeTXT:   dnsmessage.TypeTXT,
+}
+
+// lookupRecordsWire queries the records of type rtype of name through
+// exchange, for the wire resolvers implementing RecordResolver.
+func lookupRecordsWire(ctx context.Context, exchange exchangeFunc, rtype RecordType, name string, opts wireOptions) (rrs []string, ttl time.Duration, err error) {
+	qtype, ok := wireTypes[rtype]
+	if !ok {
+		return nil, 0, ErrUnsupportedRecordType
+	}
+	return queryWire(ctx, exchange, name, qtype, opts)
+}
+
+// queryWire sends a single question of type qtype for name and returns the
+// matching answers along with their smallest TTL.
+func queryWire(ctx context.Context, exchange exchangeFunc, name string, qtype dnsmessage.Type, opts wireOptions) (rrs []string, ttl time.Duration, err error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: "invalid domain name", Name: name}
+	}
+	id, err := newQueryID()
+	if err != nil {
+		return nil, 0, err
+	}
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	if opts.dnssec || opts.subnet != nil {
+		// Setting AD in the query asks for it in the response (RFC 6840
+		// section 5.7), DO for the DNSSEC records (RFC 3225).
+		query.Header.AuthenticData = opts.dnssec
+		var opt dnsmessage.ResourceHeader
+		if err = opt.SetEDNS0(ednsUDPSize, dnsmessage.RCodeSuccess, opts.dnssec); err != nil {
+			return nil, 0, err
+		}
+		body := &dnsmessage.OPTResource{}
+		if opts.subnet != nil {
+			body.Options = append(body.Options, ecsOption(opts.subnet))
+		}
+		query.Additionals = []dnsmessage.Resource{{Header: opt, Body: body}}
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := exchange(ctx, packed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !h.Response || h.ID != id {
+		return nil, 0, errResponseMismatch
+	}
+	if opts.dnssec && !h.AuthenticData && (h.RCode == dnsmessage.RCodeSuccess || h.RCode == dnsmessage.RCodeNameError) {
+		return nil, 0, ErrUnauthenticatedAnswer
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, errNoSuchHost(name)
+	default:
+		return nil, 0, &net.DNSError{
+			Err:         "server misbehaving: " + h.RCode.String(),
+			Name:        name,
+			IsTemporary: h.RCode == dnsmessage.RCodeServerFailure,
+		}
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if rh.Class != dnsmessage.ClassINET || rh.Type != qtype {
+			if err = p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		var rr string
+		switch qtype {
+		case dnsmessage.TypeA:
+			res, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = net.IP(res.A[:]).String()
+		case dnsmessage.TypeAAAA:
+			res, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = net.IP(res.AAAA[:]).String()
+		case dnsmessage.TypePTR:
+			res, err := p.PTRResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = res.PTR.String()
+		case dnsmessage.TypeCNAME:
+			res, err := p.CNAMEResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = res.CNAME.String()
+		case dnsmessage.TypeMX:
+			res, err := p.MXResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = formatMX(res.Pref, res.MX.String())
+		case dnsmessage.TypeSRV:
+			res, err := p.SRVResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = formatSRV(res.Priority, res.Weight, res.Port, res.Target.String())
+		case dnsmessage.TypeTXT:
+			res, err := p.TXTResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rr = strings.Join(res.TXT, "")
+		default:
+			return nil, 0, fmt.Errorf("dnscache: unsupported query type %v", qtype)
+		}
+
+		recordTTL := time.Duration(rh.TTL) * time.Second
+		if len(rrs) == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, ttl, nil
+}
+
+// writeStreamMessage writes msg prefixed with its two byte length, as
+// required for DNS over stream transports (RFC 1035 section 4.2.2).
+func writeStreamMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readStreamMessage reads a single length prefixed DNS message from r.
+func readStreamMessage(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// bindConn applies the deadline of ctx to conn and unblocks pending reads
+// and writes once ctx is cancelled. The returned function must be called
+// when the exchange is over; it clears the deadline so conn can be reused.
+func bindConn(ctx context.Context, conn net.Conn) (release func()) {
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+func newQueryID() (uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+func fqdn(name string) string {
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		return name
+	}
+	return name + "."
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name used to look up addr.
+func reverseName(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+	const hexDigit = "0123456789abcdef"
+	buf := make([]byte, 0, len(ip)*4+len("ip6.arpa."))
+	for i := len(ip) - 1; i >= 0; i-- {
+		buf = append(buf, hexDigit[ip[i]&0xf], '.', hexDigit[ip[i]>>4], '.')
+	}
+	return string(append(buf, "ip6.arpa."...)), nil
+}
+
+func errNoSuchHost(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}